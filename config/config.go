@@ -0,0 +1,306 @@
+// Package config holds the mount-wide settings of kubefs. Settings are read
+// from an optional YAML file and may then be overridden on the command line.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Duration wraps time.Duration so that it can be written as a string such as
+// "90s" or "5m" in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\" | %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// Config is the complete set of options for a kubefs mount.
+type Config struct {
+	// MountPoint is the directory on which the filesystem is mounted.
+	MountPoint string `json:"mountPoint,omitempty"`
+	// Kubeconfig is an explicit path to a kubeconfig file. When empty the
+	// standard loading rules apply, so $KUBECONFIG and ~/.kube/config are
+	// merged just like kubectl does.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// Contexts is an allowlist of glob patterns. Only matching kubeconfig
+	// contexts are exposed. An empty list exposes every context.
+	Contexts []string `json:"contexts,omitempty"`
+
+	ReadOnly   bool `json:"readOnly,omitempty"`
+	AllowOther bool `json:"allowOther,omitempty"`
+	Debug      bool `json:"debug,omitempty"`
+	// ReadWrite lets objects be edited, applied, created and deleted
+	// without first unlocking them with a .unlock file.
+	ReadWrite bool `json:"readWrite,omitempty"`
+
+	// APIResourceTTL is how long the discovered API resources of a
+	// context are cached for.
+	APIResourceTTL Duration `json:"apiResourceTTL,omitempty"`
+	// CacheMaxEntries and CacheMaxBytes cap the cache shared by the
+	// mount. Least recently used entries are evicted first. Zero means no
+	// limit.
+	CacheMaxEntries int   `json:"cacheMaxEntries,omitempty"`
+	CacheMaxBytes   int64 `json:"cacheMaxBytes,omitempty"`
+	// WatchCache serves listings and definitions from informers instead of
+	// issuing a LIST for every readdir. Informers are started on first
+	// access and stopped after WatchIdleTimeout without use.
+	WatchCache       bool     `json:"watchCache,omitempty"`
+	WatchIdleTimeout Duration `json:"watchIdleTimeout,omitempty"`
+	// Notify watches every resource directory for as long as the kernel
+	// holds it, as it does while inotify watches it, and tells the kernel
+	// as objects are added, changed and deleted. WatchCache implies it.
+	Notify bool `json:"notify,omitempty"`
+	// ListPageSize is how many objects are requested at a time when
+	// listing a resource directory. Zero fetches everything at once.
+	ListPageSize int64 `json:"listPageSize,omitempty"`
+	// FieldManager is the field manager server-side applies are made as.
+	// With ApplyForce set, applies take ownership of fields managed by
+	// others instead of failing with a conflict.
+	FieldManager string `json:"fieldManager,omitempty"`
+	ApplyForce   bool   `json:"applyForce,omitempty"`
+	// DeleteContexts are glob patterns of the contexts in which objects may
+	// be deleted, unless the policy says otherwise. Deletion is refused
+	// everywhere else, so that a stray `rm -rf` can't take out production.
+	DeleteContexts []string `json:"deleteContexts,omitempty"`
+	// ExecContexts are glob patterns of the contexts in which commands may
+	// be run in containers, unless the policy says otherwise. Like deletion,
+	// exec is refused everywhere else.
+	ExecContexts []string `json:"execContexts,omitempty"`
+	// DeleteGracePeriodSeconds and DeletePropagation are the defaults for
+	// deletions, which a resource directory's xattrs may override. Left
+	// unset, the API server decides.
+	DeleteGracePeriodSeconds *int64 `json:"deleteGracePeriodSeconds,omitempty"`
+	DeletePropagation        string `json:"deletePropagation,omitempty"`
+	// PolicyFile is the path of the policy deciding which operations are
+	// allowed in which contexts. Policy is what was loaded from it.
+	PolicyFile string  `json:"policyFile,omitempty"`
+	Policy     *Policy `json:"-"`
+	// AuditLog is the file unlocks and changes to clusters are recorded
+	// in, one JSON object per line. When empty they go to the log.
+	AuditLog string `json:"auditLog,omitempty"`
+	// ClientTimeout bounds every request made to the API server.
+	ClientTimeout Duration `json:"clientTimeout,omitempty"`
+	// QPS and Burst rate limit the requests made to each context.
+	QPS   float32 `json:"qps,omitempty"`
+	Burst int     `json:"burst,omitempty"`
+	// CredentialCheck is how often the kubeconfig is re-read to pick up
+	// rotated credentials.
+	CredentialCheck Duration `json:"credentialCheck,omitempty"`
+	// EntryTimeout and AttrTimeout control how long the kernel may cache
+	// lookups and attributes. They default to zero, as listings change
+	// underneath us, unless the watch cache is enabled and can tell the
+	// kernel about changes; see KernelTimeouts.
+	EntryTimeout Duration `json:"entryTimeout,omitempty"`
+	AttrTimeout  Duration `json:"attrTimeout,omitempty"`
+	// ShutdownTimeout bounds how long pending edits may take to be
+	// submitted when kubefs is asked to exit.
+	ShutdownTimeout Duration `json:"shutdownTimeout,omitempty"`
+
+	// Daemon detaches kubefs from the terminal. PidFile, LogFile and
+	// ControlSocket default to paths under the user's runtime dir when
+	// running as a daemon. ControlSocket may also be set in the foreground.
+	Daemon        bool   `json:"daemon,omitempty"`
+	PidFile       string `json:"pidFile,omitempty"`
+	LogFile       string `json:"logFile,omitempty"`
+	ControlSocket string `json:"controlSocket,omitempty"`
+}
+
+const defaultMountPoint = "/tmp/kubefs"
+
+// watchCacheKernelTimeout is how long the kernel caches entries and
+// attributes by default when the watch cache is enabled. Watched directories
+// are invalidated as soon as they change; this bounds the staleness of the
+// rest of the tree.
+const watchCacheKernelTimeout = 10 * time.Second
+
+// Default returns the configuration used when nothing is overridden.
+func Default() *Config {
+	return &Config{
+		MountPoint:       defaultMountPoint,
+		APIResourceTTL:   Duration{1 * time.Minute},
+		CacheMaxEntries:  10000,
+		CacheMaxBytes:    64 << 20,
+		WatchIdleTimeout: Duration{5 * time.Minute},
+		ListPageSize:     500,
+		FieldManager:     "kubefs",
+		ClientTimeout:    Duration{3 * time.Second},
+		QPS:              20,
+		Burst:            40,
+		CredentialCheck:  Duration{30 * time.Second},
+		ShutdownTimeout:  Duration{10 * time.Second},
+	}
+}
+
+// DefaultPath is where the config file is looked for when no path is given.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kubefs", "config.yaml")
+}
+
+// RuntimePath returns the path of name within a per-user directory for
+// sockets and pidfiles, preferring $XDG_RUNTIME_DIR.
+func RuntimePath(name string) string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("kubefs-%d", os.Getuid()))
+	} else {
+		dir = filepath.Join(dir, "kubefs")
+	}
+	return filepath.Join(dir, name)
+}
+
+// ApplyDaemonDefaults fills in the daemon paths which were left empty.
+func (c *Config) ApplyDaemonDefaults() {
+	if c.PidFile == "" {
+		c.PidFile = RuntimePath("kubefs.pid")
+	}
+	if c.ControlSocket == "" {
+		c.ControlSocket = RuntimePath("kubefs.sock")
+	}
+	if c.LogFile == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			dir = os.TempDir()
+		}
+		c.LogFile = filepath.Join(dir, "kubefs", "kubefs.log")
+	}
+}
+
+// Notifications reports whether resource directories are watched so that the
+// kernel hears of changes to them.
+func (c *Config) Notifications() bool {
+	return c.Notify || c.WatchCache
+}
+
+// KernelTimeouts returns the entry and attribute timeouts to mount with. With
+// the watch cache enabled, timeouts left at zero default to
+// watchCacheKernelTimeout.
+func (c *Config) KernelTimeouts() (entry, attr time.Duration) {
+	entry, attr = c.EntryTimeout.Duration, c.AttrTimeout.Duration
+	if c.WatchCache {
+		if entry == 0 {
+			entry = watchCacheKernelTimeout
+		}
+		if attr == 0 {
+			attr = watchCacheKernelTimeout
+		}
+	}
+	return entry, attr
+}
+
+// Load reads the YAML file at path on top of the defaults. If mustExist is
+// false a missing file is not an error and the defaults are returned.
+func Load(path string, mustExist bool) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !mustExist {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file | %w", err)
+	}
+
+	err = yaml.UnmarshalStrict(b, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %v | %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the config for values which would make the mount unusable.
+func (c *Config) Validate() error {
+	if c.MountPoint == "" {
+		return fmt.Errorf("no mount point given")
+	}
+	if c.ReadOnly && c.ReadWrite {
+		return fmt.Errorf("read-only and read-write are mutually exclusive")
+	}
+	if c.CacheMaxEntries < 0 || c.CacheMaxBytes < 0 {
+		return fmt.Errorf("cache limits must not be negative")
+	}
+	if c.ListPageSize < 0 {
+		return fmt.Errorf("list page size must not be negative")
+	}
+	if c.FieldManager == "" {
+		return fmt.Errorf("field manager must not be empty")
+	}
+	if c.QPS <= 0 || c.Burst <= 0 {
+		return fmt.Errorf("qps and burst must be positive")
+	}
+	for _, patterns := range [][]string{c.Contexts, c.DeleteContexts, c.ExecContexts} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid context pattern %q | %w", pattern, err)
+			}
+		}
+	}
+	if c.DeleteGracePeriodSeconds != nil && *c.DeleteGracePeriodSeconds < 0 {
+		return fmt.Errorf("delete grace period must not be negative")
+	}
+	if !ValidPropagation(c.DeletePropagation) {
+		return fmt.Errorf("delete propagation must be Foreground, Background or Orphan, not %q", c.DeletePropagation)
+	}
+	if c.APIResourceTTL.Duration < 0 || c.ClientTimeout.Duration < 0 ||
+		c.EntryTimeout.Duration < 0 || c.AttrTimeout.Duration < 0 ||
+		c.ShutdownTimeout.Duration < 0 || c.CredentialCheck.Duration < 0 ||
+		c.WatchIdleTimeout.Duration < 0 {
+		return fmt.Errorf("timeouts and TTLs must not be negative")
+	}
+	return nil
+}
+
+// ContextAllowed reports whether the named context matches the allowlist.
+func (c *Config) ContextAllowed(name string) bool {
+	if len(c.Contexts) == 0 {
+		return true
+	}
+	return matchesAny(c.Contexts, name)
+}
+
+// ValidPropagation reports whether policy is a deletion propagation policy,
+// or empty to leave it to the API server.
+func ValidPropagation(policy string) bool {
+	switch policy {
+	case "", "Foreground", "Background", "Orphan":
+		return true
+	}
+	return false
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Operation is a kind of access to a cluster which the policy governs.
+type Operation string
+
+const (
+	// OpRead is listing and reading objects, logs included.
+	OpRead Operation = "read"
+	// OpEdit is changing objects through edit and apply files.
+	OpEdit Operation = "edit"
+	// OpCreate is creating objects by writing new files.
+	OpCreate Operation = "create"
+	// OpDelete is deleting objects by removing their directories.
+	OpDelete Operation = "delete"
+	// OpExec is running commands in containers.
+	OpExec Operation = "exec"
+	// OpSecrets is reading and writing the contents of Secrets, on top of
+	// the read, edit or create needed anyway.
+	OpSecrets Operation = "secrets"
+)
+
+var operations = []Operation{OpRead, OpEdit, OpCreate, OpDelete, OpExec, OpSecrets}
+
+// Decision is the policy's verdict on an operation.
+type Decision int
+
+const (
+	Denied Decision = iota
+	Allowed
+	// NeedsConfirmation means the operation is allowed only while its
+	// context is unlocked.
+	NeedsConfirmation
+)
+
+const defaultUnlockFor = 5 * time.Minute
+
+// PolicyRule sets which operations are allowed in the contexts matching any
+// of its glob patterns.
+type PolicyRule struct {
+	Contexts []string    `json:"contexts"`
+	Allow    []Operation `json:"allow,omitempty"`
+	// Confirm are the allowed operations which also need the context to be
+	// unlocked first.
+	Confirm []Operation `json:"confirm,omitempty"`
+}
+
+// Policy is a list of rules, of which the first matching a context applies.
+// Contexts which match no rule get the default policy; see Config.Check.
+type Policy struct {
+	Rules []PolicyRule `json:"rules,omitempty"`
+	// UnlockFor is how long a context stays unlocked once its .unlock file
+	// is written.
+	UnlockFor Duration `json:"unlockFor,omitempty"`
+}
+
+// DefaultPolicyPath is where the policy file is looked for when no path is
+// given.
+func DefaultPolicyPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kubefs", "policy.yaml")
+}
+
+// LoadPolicy reads c.PolicyFile, or the default policy file if it is unset,
+// into c.Policy. Only an explicitly given file must exist.
+func (c *Config) LoadPolicy() error {
+	file, mustExist := c.PolicyFile, true
+	if file == "" {
+		file, mustExist = DefaultPolicyPath(), false
+	}
+	if file == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) && !mustExist {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read policy file | %w", err)
+	}
+
+	policy := &Policy{}
+	err = yaml.UnmarshalStrict(b, policy)
+	if err != nil {
+		return fmt.Errorf("failed to parse policy file %v | %w", file, err)
+	}
+	err = policy.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid policy file %v | %w", file, err)
+	}
+	c.Policy = policy
+	return nil
+}
+
+// Validate checks the policy for unknown operations and bad patterns.
+func (p *Policy) Validate() error {
+	for i, rule := range p.Rules {
+		if len(rule.Contexts) == 0 {
+			return fmt.Errorf("rule %v matches no contexts", i+1)
+		}
+		for _, pattern := range rule.Contexts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid context pattern %q in rule %v | %w", pattern, i+1, err)
+			}
+		}
+		for _, op := range append(append([]Operation(nil), rule.Allow...), rule.Confirm...) {
+			if !validOperation(op) {
+				return fmt.Errorf("unknown operation %q in rule %v, expected one of %v", op, i+1, operations)
+			}
+		}
+	}
+	if p.UnlockFor.Duration < 0 {
+		return fmt.Errorf("unlockFor must not be negative")
+	}
+	return nil
+}
+
+func validOperation(op Operation) bool {
+	for _, known := range operations {
+		if op == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *PolicyRule) decide(op Operation) Decision {
+	for _, confirm := range r.Confirm {
+		if confirm == op {
+			return NeedsConfirmation
+		}
+	}
+	for _, allow := range r.Allow {
+		if allow == op {
+			return Allowed
+		}
+	}
+	return Denied
+}
+
+// Check decides whether op may be done in the named context. The first policy
+// rule matching the context decides. Without one, everything is allowed bar
+// deletion and exec, which need the context to match DeleteContexts and
+// ExecContexts respectively.
+func (c *Config) Check(contextName string, op Operation) Decision {
+	if c.Policy != nil {
+		for _, rule := range c.Policy.Rules {
+			if matchesAny(rule.Contexts, contextName) {
+				return rule.decide(op)
+			}
+		}
+	}
+
+	switch op {
+	case OpDelete:
+		if matchesAny(c.DeleteContexts, contextName) {
+			return Allowed
+		}
+		return Denied
+	case OpExec:
+		if matchesAny(c.ExecContexts, contextName) {
+			return Allowed
+		}
+		return Denied
+	default:
+		return Allowed
+	}
+}
+
+// UnlockFor returns how long an unlocked context stays unlocked.
+func (c *Config) UnlockFor() time.Duration {
+	if c.Policy == nil || c.Policy.UnlockFor.Duration == 0 {
+		return defaultUnlockFor
+	}
+	return c.Policy.UnlockFor.Duration
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+	"rorycrispin.co.uk/kubefs/resources"
+)
+
+// The control protocol is a single line command, answered with plain text
+// after which the connection is closed. It can be driven with 'kubefs ctl'
+// or any tool which speaks to unix sockets.
+const (
+	ctlStatus  = "status"
+	ctlReload  = "reload"
+	ctlFlush   = "flush"
+	ctlUnmount = "unmount"
+
+	ctlTimeout = 10 * time.Second
+)
+
+type controlServer struct {
+	listener net.Listener
+	cfg      *config.Config
+	root     *resources.RootContextNode
+	started  time.Time
+
+	unmountRequests chan<- struct{}
+}
+
+// listenControl starts serving control commands on cfg.ControlSocket.
+func listenControl(cfg *config.Config, root *resources.RootContextNode, unmountRequests chan<- struct{}) (*controlServer, error) {
+	err := os.MkdirAll(filepath.Dir(cfg.ControlSocket), 0o700)
+	if err != nil {
+		return nil, err
+	}
+	err = removeStaleSocket(cfg.ControlSocket)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", cfg.ControlSocket)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(cfg.ControlSocket, 0o600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	s := &controlServer{
+		listener: listener,
+		cfg:      cfg,
+		root:     root,
+		started:  time.Now(),
+
+		unmountRequests: unmountRequests,
+	}
+	go s.serve()
+	return s, nil
+}
+
+// removeStaleSocket removes a socket left behind by a kubefs which died, but
+// refuses to steal the socket of one which is still listening.
+func removeStaleSocket(path string) error {
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("another kubefs is listening on %s", path)
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *controlServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Control socket accept failed | %v", err)
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *controlServer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ctlTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return
+	}
+	cmd := strings.TrimSpace(line)
+	log.Printf("Control command: %q", cmd)
+
+	switch cmd {
+	case ctlStatus:
+		fmt.Fprint(conn, s.status())
+	case ctlReload:
+		contexts, err := kube.GetK8sContexts()
+		if err != nil {
+			fmt.Fprintf(conn, "error: failed to load kubeconfig | %v\n", err)
+			return
+		}
+		kube.ResetClients()
+		s.root.FlushCaches()
+		fmt.Fprintf(conn, "ok: reloaded kubeconfig with %d contexts\n", len(contexts))
+	case ctlFlush:
+		s.root.FlushCaches()
+		fmt.Fprintln(conn, "ok: caches flushed")
+	case ctlUnmount:
+		select {
+		case s.unmountRequests <- struct{}{}:
+		default:
+		}
+		fmt.Fprintln(conn, "ok: unmounting")
+	default:
+		fmt.Fprintf(conn, "error: unknown command %q, expected one of %s, %s, %s, %s\n",
+			cmd, ctlStatus, ctlReload, ctlFlush, ctlUnmount)
+	}
+}
+
+func (s *controlServer) status() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "pid:         %d\n", os.Getpid())
+	fmt.Fprintf(b, "mountpoint:  %s\n", s.cfg.MountPoint)
+	fmt.Fprintf(b, "uptime:      %s\n", time.Since(s.started).Round(time.Second))
+	fmt.Fprintf(b, "read-only:   %v\n", s.cfg.ReadOnly)
+	if len(s.cfg.Contexts) > 0 {
+		fmt.Fprintf(b, "contexts:    %s\n", strings.Join(s.cfg.Contexts, ", "))
+	} else {
+		fmt.Fprintf(b, "contexts:    all\n")
+	}
+	stats := s.root.CacheStats()
+	fmt.Fprintf(b, "cached:      %d entries, ~%d bytes\n", stats.Entries, stats.Bytes)
+	fmt.Fprintf(b, "cache:       %d hits, %d misses, %d evictions\n", stats.Hits, stats.Misses, stats.Evictions)
+	fmt.Fprintf(b, "pinned:      %d entries\n", stats.Pinned)
+	return b.String()
+}
+
+// runCtl implements 'kubefs ctl <command>', a client for the control socket.
+func runCtl(args []string) int {
+	flags := flag.NewFlagSet("kubefs ctl", flag.ContinueOnError)
+	socket := flags.String("control-socket", config.RuntimePath("kubefs.sock"), "control socket of the running kubefs")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: kubefs ctl [flags] %s|%s|%s|%s\n\n", ctlStatus, ctlReload, ctlFlush, ctlUnmount)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	conn, err := net.DialTimeout("unix", *socket, ctlTimeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kubefs ctl: failed to connect to %s | %v\n", *socket, err)
+		return 1
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ctlTimeout))
+
+	_, err = fmt.Fprintln(conn, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kubefs ctl: %v\n", err)
+		return 1
+	}
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kubefs ctl: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(resp)
+	if strings.HasPrefix(string(resp), "error:") {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"rorycrispin.co.uk/kubefs/config"
+)
+
+// daemonEnv is set in the environment of the re-executed child so that it
+// knows not to daemonize again.
+const daemonEnv = "KUBEFS_DAEMON_CHILD"
+
+// readyFd is the file descriptor over which the child tells the parent that
+// the filesystem was mounted. It's the first of cmd.ExtraFiles.
+const readyFd = 3
+
+func isDaemonChild() bool {
+	return os.Getenv(daemonEnv) == "1"
+}
+
+// daemonize re-executes kubefs detached from the terminal, with output sent
+// to the log file. It waits until the child has mounted the filesystem, or
+// failed to, and returns the status for the parent to exit with.
+func daemonize(cfg *config.Config) int {
+	err := os.MkdirAll(filepath.Dir(cfg.LogFile), 0o700)
+	if err != nil {
+		log.Printf("Failed to create log directory | %v", err)
+		return 1
+	}
+	logFile, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		log.Printf("Failed to open log file | %v", err)
+		return 1
+	}
+	defer logFile.Close()
+
+	self, err := os.Executable()
+	if err != nil {
+		log.Printf("Failed to find the kubefs executable | %v", err)
+		return 1
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		log.Printf("Failed to create pipe | %v", err)
+		return 1
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(self, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.ExtraFiles = []*os.File{readyW}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		log.Printf("Failed to start daemon | %v", err)
+		return 1
+	}
+
+	// The child writes to the pipe once mounted. If it exits first, the
+	// pipe is closed without a message.
+	msg, _ := io.ReadAll(ready)
+	if strings.TrimSpace(string(msg)) != "ready" {
+		log.Printf("kubefs failed to start, see %s", cfg.LogFile)
+		return 1
+	}
+	fmt.Printf("kubefs running as pid %d, mounted on %s\n", cmd.Process.Pid, cfg.MountPoint)
+	fmt.Printf("Logging to %s, control socket at %s\n", cfg.LogFile, cfg.ControlSocket)
+	return 0
+}
+
+// notifyDaemonParent tells the waiting parent that the mount succeeded.
+func notifyDaemonParent() {
+	if !isDaemonChild() {
+		return
+	}
+	f := os.NewFile(readyFd, "ready")
+	if f == nil {
+		return
+	}
+	fmt.Fprintln(f, "ready")
+	f.Close()
+}
+
+// writePidFile writes our pid to path, refusing to clobber the pidfile of
+// another kubefs which is still running.
+func writePidFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err == nil && pid != os.Getpid() && syscall.Kill(pid, 0) == nil {
+			return fmt.Errorf("kubefs is already running as pid %d (%s)", pid, path)
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0o644)
+}
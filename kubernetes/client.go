@@ -2,24 +2,61 @@ package kubernetes
 
 import (
 	"fmt"
-	"path/filepath"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/discovery"
-	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
 type void struct{}
 var member void
 
+// ClientOptions are the mount-wide settings applied to every client built by
+// this package.
+type ClientOptions struct {
+	// Kubeconfig is an explicit kubeconfig path. When empty the default
+	// loading rules ($KUBECONFIG, ~/.kube/config) are used.
+	Kubeconfig string
+	// Timeout bounds each request made to the API server.
+	Timeout time.Duration
+	// QPS and Burst rate limit the requests made to each context.
+	QPS   float32
+	Burst int
+	// CredentialCheck is how often the kubeconfig is re-read to notice
+	// rotated credentials.
+	CredentialCheck time.Duration
+	// WatchIdleTimeout is how long an unused Watch is kept running.
+	WatchIdleTimeout time.Duration
+}
+
+var clientOptions = ClientOptions{
+	Timeout:         3 * time.Second,
+	QPS:             20,
+	Burst:           40,
+	CredentialCheck: 30 * time.Second,
+	WatchIdleTimeout: 5 * time.Minute,
+}
+
+// Configure sets the options used by all clients created afterwards. Clients
+// which already exist are rebuilt.
+func Configure(opts ClientOptions) {
+	clientOptions = opts
+	ResetClients()
+}
+
+func loadingRules() *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if clientOptions.Kubeconfig != "" {
+		rules.ExplicitPath = clientOptions.Kubeconfig
+	}
+	return rules
+}
+
 func GetK8sContexts() ([]string, error) {
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules := loadingRules()
 
 	configOverrides := &clientcmd.ConfigOverrides{}
 
@@ -43,55 +80,33 @@ func GetK8sContexts() ([]string, error) {
 }
 
 func GetK8sClientConfig(contextName string) (*rest.Config, error) {
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules := loadingRules()
 
 	configOverrides := &clientcmd.ConfigOverrides{}
 	if contextName != "" {
 		configOverrides.CurrentContext = contextName
 	}
 
-	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides).ClientConfig()
-	if err != nil {
-		return nil, err
-	}
-	return config, nil
-}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
 
-
-func GetK8sClient(kCtx string) (*kubernetes.Clientset, error) {
-	config, err := GetK8sClientConfig(kCtx)
-	if err != nil {
-		return nil, err
+	// An override naming a context which doesn't exist must not fall back
+	// to the current context, or a request for one cluster could be sent to
+	// another.
+	if contextName != "" {
+		raw, err := clientConfig.RawConfig()
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := raw.Contexts[contextName]; !exists {
+			return nil, fmt.Errorf("context %q not found in kubeconfig", contextName)
+		}
 	}
 
-	config.Timeout = 3 * time.Second
-
-	// create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
+	config, err := clientConfig.ClientConfig()
 	if err != nil {
 		return nil, err
 	}
-
-	return clientset, nil
-}
-
-func getK8sUnstructuredClient() dynamic.Interface {
-	kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
-
-	// use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	config.Timeout = 3 * time.Second
-	if err != nil {
-		panic(err.Error())
-	}
-
-	// create the clientset
-	clientset, err := dynamic.NewForConfig(config)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	return clientset
+	return config, nil
 }
 
 func GetApiResources(cli *discovery.DiscoveryClient) (*[]*metav1.APIResourceList, error){
@@ -105,18 +120,3 @@ func GetApiResources(cli *discovery.DiscoveryClient) (*[]*metav1.APIResourceList
 	}
 	return &apiResourceList, nil
 }
-
-func GetK8sDiscoveryClient(contextName string) (*discovery.DiscoveryClient, error) {
-	config, err := GetK8sClientConfig(contextName)
-	if err != nil {
-		return nil, err
-	}
-
-	// create the clientset
-	clientset, err := discovery.NewDiscoveryClientForConfig(config)
-	if err != nil {
-		return nil, err
-	}
-
-	return clientset, nil
-}
@@ -0,0 +1,226 @@
+package kubernetes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// ContextClients owns every client for a single kubeconfig context. Clients
+// are built lazily on first use and shared by all callers. The kubeconfig is
+// re-read at most every ClientOptions.CredentialCheck, and if the
+// credentials for the context have changed all clients are rebuilt.
+type ContextClients struct {
+	contextName string
+
+	mu          sync.Mutex
+	config      *rest.Config
+	fingerprint string
+	checkedAt   time.Time
+
+	typed     *kubernetes.Clientset
+	dynamic   dynamic.Interface
+	discovery *discovery.DiscoveryClient
+
+	watchDynamic dynamic.Interface
+	watches      map[watchKey]*Watch
+	reaping      bool
+}
+
+var clientManagers = struct {
+	sync.Mutex
+	byContext map[string]*ContextClients
+}{
+	byContext: make(map[string]*ContextClients),
+}
+
+// ClientsFor returns the client manager of the named context.
+func ClientsFor(contextName string) *ContextClients {
+	clientManagers.Lock()
+	defer clientManagers.Unlock()
+
+	c, exists := clientManagers.byContext[contextName]
+	if !exists {
+		c = &ContextClients{contextName: contextName}
+		clientManagers.byContext[contextName] = c
+	}
+	return c
+}
+
+// ResetClients drops the clients of every context, so that they are rebuilt
+// from the kubeconfig on next use.
+func ResetClients() {
+	clientManagers.Lock()
+	defer clientManagers.Unlock()
+
+	for _, c := range clientManagers.byContext {
+		c.Invalidate()
+	}
+}
+
+// Invalidate drops the clients of this context so that they are rebuilt on
+// next use.
+func (c *ContextClients) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+}
+
+func (c *ContextClients) reset() {
+	c.config = nil
+	c.fingerprint = ""
+	c.typed = nil
+	c.dynamic = nil
+	c.discovery = nil
+	c.watchDynamic = nil
+	c.stopWatches()
+}
+
+// observe invalidates the clients if err shows that our credentials were
+// rejected, as they have most likely been rotated.
+func (c *ContextClients) observe(err error) {
+	if kube_errors.IsUnauthorized(err) {
+		log.Printf("Credentials for context %v were rejected, rebuilding clients", c.contextName)
+		c.Invalidate()
+	}
+}
+
+// ensureConfig loads the rest config for the context, rebuilding the clients
+// if the credentials changed since they were built. Callers must hold c.mu.
+func (c *ContextClients) ensureConfig() (*rest.Config, error) {
+	if c.config != nil && time.Since(c.checkedAt) < clientOptions.CredentialCheck {
+		return c.config, nil
+	}
+	if c.contextName == "" {
+		// GetK8sClientConfig would quietly use the current context.
+		return nil, fmt.Errorf("no context given")
+	}
+
+	config, err := GetK8sClientConfig(c.contextName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get k8s config for context %v | %w", c.contextName, err)
+	}
+	config.Timeout = clientOptions.Timeout
+	config.QPS = clientOptions.QPS
+	config.Burst = clientOptions.Burst
+
+	fingerprint := credentialFingerprint(config)
+	if fingerprint != c.fingerprint {
+		if c.fingerprint != "" {
+			log.Printf("Credentials for context %v changed, rebuilding clients", c.contextName)
+		}
+		c.reset()
+		c.fingerprint = fingerprint
+	}
+	c.config = config
+	c.checkedAt = time.Now()
+	return config, nil
+}
+
+// Config returns a copy of the rest config used by this context's clients.
+func (c *ContextClients) Config() (*rest.Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	config, err := c.ensureConfig()
+	if err != nil {
+		return nil, err
+	}
+	return rest.CopyConfig(config), nil
+}
+
+// Typed returns the typed clientset.
+func (c *ContextClients) Typed() (*kubernetes.Clientset, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	config, err := c.ensureConfig()
+	if err != nil {
+		return nil, err
+	}
+	if c.typed == nil {
+		c.typed, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make client from k8s config | %w", err)
+		}
+	}
+	return c.typed, nil
+}
+
+// REST returns a REST client suitable for requests built with AbsPath.
+func (c *ContextClients) REST() (rest.Interface, error) {
+	cli, err := c.Typed()
+	if err != nil {
+		return nil, err
+	}
+	return cli.CoreV1().RESTClient(), nil
+}
+
+// Dynamic returns the dynamic client, used for arbitrary resources.
+func (c *ContextClients) Dynamic() (dynamic.Interface, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	config, err := c.ensureConfig()
+	if err != nil {
+		return nil, err
+	}
+	if c.dynamic == nil {
+		c.dynamic, err = dynamic.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make dynamic client from k8s config | %w", err)
+		}
+	}
+	return c.dynamic, nil
+}
+
+// Discovery returns the discovery client. Discovery of every API group can be
+// slow on big clusters, so it isn't bound by the request timeout.
+func (c *ContextClients) Discovery() (*discovery.DiscoveryClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	config, err := c.ensureConfig()
+	if err != nil {
+		return nil, err
+	}
+	if c.discovery == nil {
+		config = rest.CopyConfig(config)
+		config.Timeout = 0
+		c.discovery, err = discovery.NewDiscoveryClientForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make discovery client from k8s config | %w", err)
+		}
+	}
+	return c.discovery, nil
+}
+
+// credentialFingerprint summarises everything in config which identifies the
+// server and the credentials used against it.
+func credentialFingerprint(config *rest.Config) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v\x00%v\x00%v\x00%v\x00%v\x00%v\x00%v\x00%v\x00",
+		config.Host, config.Username, config.Password,
+		config.BearerToken, config.BearerTokenFile,
+		config.TLSClientConfig.CertFile, config.TLSClientConfig.KeyFile, config.TLSClientConfig.CAFile,
+	)
+	h.Write(config.TLSClientConfig.CertData)
+	h.Write(config.TLSClientConfig.KeyData)
+	h.Write(config.TLSClientConfig.CAData)
+	if config.AuthProvider != nil {
+		fmt.Fprintf(h, "%v%v", config.AuthProvider.Name, config.AuthProvider.Config)
+	}
+	if config.ExecProvider != nil {
+		fmt.Fprintf(h, "%v%v%v", config.ExecProvider.Command, config.ExecProvider.Args, config.ExecProvider.Env)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
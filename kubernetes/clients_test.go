@@ -0,0 +1,125 @@
+package kubernetes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKubeconfig writes a kubeconfig holding a context, cluster and user for
+// each of names, with current-context set to current, and returns its path.
+func writeKubeconfig(t *testing.T, current string, names ...string) string {
+	t.Helper()
+	var clusters, users, contexts strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&clusters, "- name: %[1]v\n  cluster:\n    server: https://%[1]v.example:6443\n", name)
+		fmt.Fprintf(&users, "- name: %[1]v\n  user:\n    token: token-%[1]v\n", name)
+		fmt.Fprintf(&contexts, "- name: %[1]v\n  context:\n    cluster: %[1]v\n    user: %[1]v\n", name)
+	}
+	kubeconfig := fmt.Sprintf(
+		"apiVersion: v1\nkind: Config\ncurrent-context: %v\nclusters:\n%vusers:\n%vcontexts:\n%v",
+		current, clusters.String(), users.String(), contexts.String(),
+	)
+
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(kubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClientsForIsolatesContexts(t *testing.T) {
+	previous := clientOptions
+	t.Cleanup(func() { Configure(previous) })
+
+	tests := []struct {
+		name string
+		// kubeconfigEnv is $KUBECONFIG, and explicit the --kubeconfig path.
+		kubeconfigEnv func(t *testing.T) string
+		explicit      func(t *testing.T) string
+	}{
+		{
+			name: "merged from KUBECONFIG",
+			kubeconfigEnv: func(t *testing.T) string {
+				return strings.Join([]string{
+					writeKubeconfig(t, "a", "a"),
+					writeKubeconfig(t, "a", "b"),
+				}, string(filepath.ListSeparator))
+			},
+		},
+		{
+			name: "explicit kubeconfig",
+			kubeconfigEnv: func(t *testing.T) string {
+				// Must be ignored in favour of the explicit path.
+				return writeKubeconfig(t, "other", "other")
+			},
+			explicit: func(t *testing.T) string {
+				return writeKubeconfig(t, "a", "a", "b")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KUBECONFIG", tt.kubeconfigEnv(t))
+			opts := previous
+			if tt.explicit != nil {
+				opts.Kubeconfig = tt.explicit(t)
+			}
+			Configure(opts)
+
+			a, b := ClientsFor("a"), ClientsFor("b")
+			if a == b {
+				t.Fatalf("contexts a and b share a client manager")
+			}
+
+			for name, c := range map[string]*ContextClients{"a": a, "b": b} {
+				config, err := c.Config()
+				if err != nil {
+					t.Fatalf("context %v: %v", name, err)
+				}
+				if want := fmt.Sprintf("https://%v.example:6443", name); config.Host != want {
+					t.Errorf("context %v: host is %v, want %v", name, config.Host, want)
+				}
+				if want := "token-" + name; config.BearerToken != want {
+					t.Errorf("context %v: token is %v, want %v", name, config.BearerToken, want)
+				}
+			}
+
+			typedA, err := a.Typed()
+			if err != nil {
+				t.Fatal(err)
+			}
+			typedB, err := b.Typed()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typedA == typedB {
+				t.Errorf("contexts a and b share a typed clientset")
+			}
+			dynamicA, err := a.Dynamic()
+			if err != nil {
+				t.Fatal(err)
+			}
+			dynamicB, err := b.Dynamic()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dynamicA == dynamicB {
+				t.Errorf("contexts a and b share a dynamic client")
+			}
+			if again, _ := a.Typed(); again != typedA {
+				t.Errorf("context a's typed clientset was not cached")
+			}
+
+			if _, err := ClientsFor("missing").Config(); err == nil {
+				t.Errorf("unknown context resolved instead of failing")
+			}
+			if _, err := GetK8sClientConfig("missing"); err == nil {
+				t.Errorf("unknown context fell back to the current context")
+			}
+		})
+	}
+}
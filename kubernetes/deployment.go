@@ -11,6 +11,9 @@ import (
 
 
 func GetDeployments(ctx context.Context, cli *kubernetes.Clientset, namespace string) ([]string, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
 	results, err := cli.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, err
@@ -25,6 +28,9 @@ func GetDeployments(ctx context.Context, cli *kubernetes.Clientset, namespace st
 }
 
 func GetDeploymentDefinition(ctx context.Context, cli *kubernetes.Clientset, name, namespace string) ([]byte, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
 	rv, err := cli.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
 	if kube_errors.IsNotFound(err) {
 		return nil, ErrNotFound
@@ -0,0 +1,194 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// maxDiagnosisEvents is how many of a pod's most recent events a diagnosis
+// includes.
+const maxDiagnosisEvents = 10
+
+// DiagnoseContainer describes the state of a pod and of one of its
+// containers, along with the pod's recent events, to explain why the
+// container can't be exec'd in or its logs fetched. For instance, a container
+// whose image can't be pulled is shown as waiting in ImagePullBackOff.
+func DiagnoseContainer(ctx context.Context, cli *k8s.Clientset, pod, container, namespace string) ([]byte, error) {
+	p, err := getPod(ctx, cli, pod, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "pod: %v\n", p.Name)
+	fmt.Fprintf(&b, "phase: %v\n", p.Status.Phase)
+	if p.Status.Reason != "" {
+		fmt.Fprintf(&b, "reason: %v\n", p.Status.Reason)
+	}
+	if p.Status.Message != "" {
+		fmt.Fprintf(&b, "message: %v\n", p.Status.Message)
+	}
+	for _, cond := range p.Status.Conditions {
+		if cond.Status == corev1.ConditionTrue {
+			continue
+		}
+		fmt.Fprintf(&b, "condition: %v is %v", cond.Type, cond.Status)
+		if cond.Reason != "" {
+			fmt.Fprintf(&b, " (%v)", cond.Reason)
+		}
+		if cond.Message != "" {
+			fmt.Fprintf(&b, ": %v", cond.Message)
+		}
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "\ncontainer: %v\n", container)
+	status, found := containerStatus(p, container)
+	if !found {
+		if !hasContainer(p, container) {
+			b.WriteString("  the pod has no such container\n")
+		} else {
+			b.WriteString("  no status reported yet\n")
+		}
+	} else {
+		fmt.Fprintf(&b, "  state: %v\n", describeContainerState(status.State))
+		if status.LastTerminationState.Terminated != nil {
+			fmt.Fprintf(&b, "  last state: %v\n", describeContainerState(status.LastTerminationState))
+		}
+		fmt.Fprintf(&b, "  ready: %v\n", status.Ready)
+		fmt.Fprintf(&b, "  restarts: %v\n", status.RestartCount)
+		fmt.Fprintf(&b, "  image: %v\n", status.Image)
+	}
+
+	events, err := podEvents(ctx, cli, pod, namespace)
+	if err != nil {
+		// The pod's status is worth having without its events.
+		fmt.Fprintf(&b, "\nevents: failed to list | %v\n", err)
+		return []byte(b.String()), nil
+	}
+	b.WriteString("\nevents:\n")
+	if len(events) == 0 {
+		b.WriteString("  none\n")
+	}
+	for _, ev := range events {
+		fmt.Fprintf(&b, "  %v  %v  %v  %v",
+			eventTime(ev).UTC().Format(time.RFC3339), ev.Type, ev.Reason, strings.TrimSpace(ev.Message),
+		)
+		if ev.Count > 1 {
+			fmt.Fprintf(&b, " (x%v)", ev.Count)
+		}
+		b.WriteString("\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// containerStatus returns the status of the named container, which may be an
+// init container.
+func containerStatus(pod *corev1.Pod, container string) (corev1.ContainerStatus, bool) {
+	for _, statuses := range [][]corev1.ContainerStatus{
+		pod.Status.ContainerStatuses,
+		pod.Status.InitContainerStatuses,
+		pod.Status.EphemeralContainerStatuses,
+	} {
+		for _, status := range statuses {
+			if status.Name == container {
+				return status, true
+			}
+		}
+	}
+	return corev1.ContainerStatus{}, false
+}
+
+func hasContainer(pod *corev1.Pod, container string) bool {
+	for _, containers := range [][]corev1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+		for _, c := range containers {
+			if c.Name == container {
+				return true
+			}
+		}
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		if c.Name == container {
+			return true
+		}
+	}
+	return false
+}
+
+func describeContainerState(state corev1.ContainerState) string {
+	switch {
+	case state.Waiting != nil:
+		rv := "waiting"
+		if state.Waiting.Reason != "" {
+			rv = fmt.Sprintf("%v (%v)", rv, state.Waiting.Reason)
+		}
+		if state.Waiting.Message != "" {
+			rv = fmt.Sprintf("%v: %v", rv, state.Waiting.Message)
+		}
+		return rv
+	case state.Running != nil:
+		return fmt.Sprintf("running since %v", state.Running.StartedAt.UTC().Format(time.RFC3339))
+	case state.Terminated != nil:
+		t := state.Terminated
+		rv := fmt.Sprintf("terminated with exit code %v", t.ExitCode)
+		if t.Signal != 0 {
+			rv = fmt.Sprintf("%v, signal %v", rv, t.Signal)
+		}
+		if t.Reason != "" {
+			rv = fmt.Sprintf("%v (%v)", rv, t.Reason)
+		}
+		if !t.FinishedAt.IsZero() {
+			rv = fmt.Sprintf("%v at %v", rv, t.FinishedAt.UTC().Format(time.RFC3339))
+		}
+		if t.Message != "" {
+			rv = fmt.Sprintf("%v: %v", rv, strings.TrimSpace(t.Message))
+		}
+		return rv
+	default:
+		return "unknown"
+	}
+}
+
+// podEvents returns the most recent events of the pod, oldest first.
+func podEvents(ctx context.Context, cli *k8s.Clientset, pod, namespace string) ([]corev1.Event, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
+	selector := fields.Set{
+		"involvedObject.kind": "Pod",
+		"involvedObject.name": pod,
+	}.AsSelector().String()
+	list, err := cli.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return nil, requestError("GET", fmt.Sprintf("/api/v1/namespaces/%v/events", namespace), err)
+	}
+
+	events := list.Items
+	sort.Slice(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+	if len(events) > maxDiagnosisEvents {
+		events = events[len(events)-maxDiagnosisEvents:]
+	}
+	return events, nil
+}
+
+// eventTime is when the event last happened.
+func eventTime(ev corev1.Event) time.Time {
+	switch {
+	case !ev.LastTimestamp.IsZero():
+		return ev.LastTimestamp.Time
+	case !ev.EventTime.IsZero():
+		return ev.EventTime.Time
+	default:
+		return ev.CreationTimestamp.Time
+	}
+}
@@ -1,8 +1,58 @@
 package kubernetes
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
 
 
 var (
 	ErrNotFound = fmt.Errorf("object not found")
+	// ErrContinueExpired is returned when a paginated list took so long that
+	// the API server no longer holds the snapshot it was reading from.
+	ErrContinueExpired = fmt.Errorf("listing expired before it was complete, list again to start over")
 )
+
+// RequestError records the API request an error was returned for, so that
+// it can be reported alongside the error. Its message is that of Err.
+type RequestError struct {
+	Method string
+	Path   string
+	Err    error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// requestError wraps err, unless it is nil, with the request it was returned
+// for.
+func requestError(method, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RequestError{Method: method, Path: path, Err: err}
+}
+
+// apiPath returns the API path of the named object of gvr, or of the
+// collection if name is empty.
+func apiPath(gvr schema.GroupVersionResource, namespace, name string) string {
+	parts := []string{"/apis", gvr.Group, gvr.Version}
+	if gvr.Group == "" {
+		parts = []string{"/api", gvr.Version}
+	}
+	if namespace != "" {
+		parts = append(parts, "namespaces", namespace)
+	}
+	parts = append(parts, gvr.Resource)
+	if name != "" {
+		parts = append(parts, name)
+	}
+	return strings.Join(parts, "/")
+}
@@ -7,9 +7,12 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 func GetNamespaces(ctx context.Context, cli *kubernetes.Clientset) ([]string, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
 	namespaces, err := cli.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return nil, err
+		return nil, requestError("GET", "/api/v1/namespaces", err)
 	}
 	rv := make([]string, len(namespaces.Items))
 
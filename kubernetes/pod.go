@@ -6,20 +6,31 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
 
 	corev1 "k8s.io/api/core/v1"
 	kube_errors "k8s.io/apimachinery/pkg/api/errors"
 	spdyStream "k8s.io/apimachinery/pkg/util/httpstream/spdy"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/kubernetes"
 	k8s "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/apimachinery/pkg/util/httpstream"
 	"k8s.io/client-go/kubernetes/scheme"
+	utilexec "k8s.io/client-go/util/exec"
 
 )
 
+var podsGVR = corev1.SchemeGroupVersion.WithResource("pods")
+
 func GetPods(ctx context.Context, cli *k8s.Clientset, namespace string) ([]string, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
 	pods, err := cli.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, err
@@ -34,12 +45,15 @@ func GetPods(ctx context.Context, cli *k8s.Clientset, namespace string) ([]strin
 }
 
 func getPod(ctx context.Context, cli *k8s.Clientset, name, namespace string) (*corev1.Pod, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
 	pod, err := cli.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
 	if kube_errors.IsNotFound(err) {
 		return nil, ErrNotFound
 	}
 	if err != nil {
-		return nil, err
+		return nil, requestError("GET", apiPath(podsGVR, namespace, name), err)
 	}
 	return pod, nil
 }
@@ -69,47 +83,68 @@ func GetContainers(ctx context.Context, cli *k8s.Clientset, podName, namespace s
 	return rv, nil
 }
 
-func GetLogs(ctx context.Context, cli *k8s.Clientset, pod, container, namespace string) ([]byte, error) {
-	return getLogs(ctx, cli, pod, container, namespace, false)
-}
-func GetPreviousLogs(ctx context.Context, cli *k8s.Clientset, pod, container, namespace string) ([]byte, error) {
-	return getLogs(ctx, cli, pod, container, namespace, true)
-}
+// StreamLogs opens a stream of the container's logs, as selected by opts,
+// which the caller must close. The stream stays open until then, or until
+// Shutdown, so with opts.Follow set it carries new lines as they are written.
+func StreamLogs(ctx context.Context, cli *k8s.Clientset, pod, namespace string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	// The stream outlives ctx, which only bounds opening it.
+	streamCtx, cancel := withShutdown(context.Background())
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
 
-func getLogs(ctx context.Context, cli *k8s.Clientset, pod, container, namespace string, previous bool) ([]byte, error) {
-	rc, err := cli.CoreV1().Pods(namespace).GetLogs(
-		pod,
-		 &corev1.PodLogOptions{
-			 Container: container,
-			 Previous: previous,
-		 },
-		).Stream(ctx)
+	rc, err := cli.CoreV1().Pods(namespace).GetLogs(pod, opts).Stream(streamCtx)
+	if ctx.Err() != nil {
+		cancel()
+		return nil, ctx.Err()
+	}
 	if kube_errors.IsNotFound(err) {
+		cancel()
 		return nil, ErrNotFound
 	}
 	if err != nil {
-		return nil, err
+		cancel()
+		return nil, requestError("GET", apiPath(podsGVR, namespace, pod)+"/log", err)
 	}
+	return &logStream{ReadCloser: rc, cancel: cancel}, nil
+}
 
-	defer rc.Close()
+// logStream ends its request once closed.
+type logStream struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
 
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(rc)
-	return buf.Bytes(), nil
+func (s *logStream) Close() error {
+	err := s.ReadCloser.Close()
+	s.cancel()
+	return err
 }
 
+// ExecCommand runs cmd in the container and returns its stdout and stderr.
+//
+// If the command exits non-zero, its output is returned along with an error
+// for which ExitStatus reports the status.
+//
+// If ctx is cancelled, as it is when the caller is interrupted, the command's
+// stream is torn down. ExecCommand then returns at once with the output so
+// far and an error wrapping context.Canceled.
 func ExecCommand(ctx context.Context, contextName, pod, container, namespace string, cmd []string) ([]byte, []byte, error) {
-	fmt.Printf("Exec: ctx: %v pod %v container %v namespace %v cmd %v\n", contextName, pod, container, namespace, cmd)
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
 
-	if contextName != "microk8s" && contextName != "rancher-desktop" {
-		panic("disabling exec on real cluster!")
-	}
-	config, err := GetK8sClientConfig(contextName)
+	clients := ClientsFor(contextName)
+	config, err := clients.Config()
 	if err != nil {
 		return nil, nil, err
 	}
-
-	cli, err := kubernetes.NewForConfig(config)
+	cli, err := clients.Typed()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -136,22 +171,91 @@ func ExecCommand(ctx context.Context, contextName, pod, container, namespace str
 		return nil, nil, fmt.Errorf("failed creating SPDY upgrade wrapper: %w", err)
 	}
 
-	exec, err := remotecommand.NewSPDYExecutorForTransports(wrapper, upgrader, "POST", req.URL())
+	exec, err := remotecommand.NewSPDYExecutorForTransports(wrapper, &cancelableUpgrader{upgrader, ctx}, "POST", req.URL())
 	if err != nil {
 		return nil, nil, err
 	}
-	var stdoutBuf, stderrBuf bytes.Buffer
-	err = exec.Stream(remotecommand.StreamOptions{
-		Stdin: nil,
-		Stdout: &stdoutBuf,
-		Stderr: &stderrBuf,
-		Tty: false,
-	})
+	var stdoutBuf, stderrBuf syncBuffer
+	streamed := make(chan error, 1)
+	go func() {
+		streamed <- exec.Stream(remotecommand.StreamOptions{
+			Stdin: nil,
+			Stdout: &stdoutBuf,
+			Stderr: &stderrBuf,
+			Tty: false,
+		})
+	}()
+	// Stream doesn't take a context, and may still be connecting when ctx
+	// is cancelled. cancelableUpgrader closes the connection as soon as
+	// there is one, ending the stream, so it needn't be waited for.
+	select {
+	case err = <-streamed:
+	case <-ctx.Done():
+		return stdoutBuf.Bytes(), stderrBuf.Bytes(), fmt.Errorf("exec interrupted | %w", ctx.Err())
+	}
+	clients.observe(err)
+	if _, exited := ExitStatus(err); exited {
+		return stdoutBuf.Bytes(), stderrBuf.Bytes(), err
+	}
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, requestError("POST", req.URL().Path, err)
 	}
-	so := stdoutBuf.String()
-	fmt.Printf("%v %v :: %v\n",so, stdoutBuf.String(), stderrBuf.String())
-
 	return stdoutBuf.Bytes(), stderrBuf.Bytes(), err
 }
+
+// ExitStatus returns the status a command run by ExecCommand exited with, if
+// err is the command exiting non-zero rather than a failure to run it.
+func ExitStatus(err error) (int, bool) {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), true
+	}
+	return 0, false
+}
+
+// syncBuffer is a bytes.Buffer which may be read while an exec is still
+// writing to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of what has been written so far.
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func (b *syncBuffer) String() string {
+	return string(b.Bytes())
+}
+
+// cancelableUpgrader closes the upgraded exec connection once ctx is done.
+// remotecommand's Stream does not take a context, so without this an exec
+// would keep running after its caller had given up on it.
+type cancelableUpgrader struct {
+	spdy.Upgrader
+	ctx context.Context
+}
+
+func (u *cancelableUpgrader) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := u.Upgrader.NewConnection(resp)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-u.ctx.Done():
+			conn.Close()
+		case <-conn.CloseChan():
+		}
+	}()
+	return conn, nil
+}
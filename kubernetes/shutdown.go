@@ -0,0 +1,27 @@
+package kubernetes
+
+import "context"
+
+// shutdownCtx is the parent of every request made by this package, so that a
+// single call to Shutdown tears down anything still in flight.
+var shutdownCtx, cancelAll = context.WithCancel(context.Background())
+
+// Shutdown cancels every in-flight request, exec and log stream. Requests
+// made after Shutdown fail immediately with context.Canceled.
+func Shutdown() {
+	cancelAll()
+}
+
+// withShutdown returns a copy of ctx which is also cancelled by Shutdown.
+// Callers must call the returned CancelFunc once the request is complete.
+func withShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-shutdownCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
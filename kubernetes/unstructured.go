@@ -3,12 +3,17 @@ package kubernetes
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 )
 
@@ -17,15 +22,36 @@ type metadataOnlyObject struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 }
 
-func ListResourceNames(ctx context.Context, groupVersion, resource, contextName, namespace string) ([]string, error) {
-	config, err := GetK8sClientConfig(contextName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get k8s config | %w", err)
+// ListResourceNames returns the names of every object of resource, fetching
+// them limit at a time. A limit of zero lists everything in one request.
+func ListResourceNames(ctx context.Context, groupVersion, resource, contextName, namespace string, limit int64) ([]string, error) {
+	var rv []string
+	continueToken := ""
+	for {
+		names, next, err := ListResourceNamesPage(ctx, groupVersion, resource, contextName, namespace, limit, continueToken)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, names...)
+		if next == "" {
+			return rv, nil
+		}
+		continueToken = next
 	}
+}
+
+// ListResourceNamesPage returns up to limit names of resource, starting from
+// continueToken, along with the token to fetch the next page with. The
+// returned token is empty once the listing is complete. If the token has
+// expired, ErrContinueExpired is returned.
+func ListResourceNamesPage(ctx context.Context, groupVersion, resource, contextName, namespace string, limit int64, continueToken string) ([]string, string, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
 
-	cli, err := kubernetes.NewForConfig(config)
+	clients := ClientsFor(contextName)
+	cli, err := clients.REST()
 	if err != nil {
-		return nil, fmt.Errorf("failed to make client from k8s config | %w", err)
+		return nil, "", err
 	}
 
 	var pathPrefix string
@@ -37,38 +63,45 @@ func ListResourceNames(ctx context.Context, groupVersion, resource, contextName,
 
 	var req *rest.Request
 	if namespace == "" {
-		req = cli.CoreV1().RESTClient().
-			Get().AbsPath(pathPrefix, groupVersion, resource)
+		req = cli.Get().AbsPath(pathPrefix, groupVersion, resource)
 	} else {
-		req = cli.CoreV1().RESTClient().
-			Get().AbsPath(pathPrefix, groupVersion, "namespaces", namespace, resource)
+		req = cli.Get().AbsPath(pathPrefix, groupVersion, "namespaces", namespace, resource)
 	}
 
 	req.SetHeader("Accept", fmt.Sprintf("application/json;as=Table;v=%s;g=%s", metav1.SchemeGroupVersion.Version, metav1.GroupName))
-	fmt.Printf("Requesting %#v\n", req)
+	if limit > 0 {
+		req.Param("limit", strconv.FormatInt(limit, 10))
+	}
+	if continueToken != "" {
+		req.Param("continue", continueToken)
+	}
 
 	resp := req.Do(ctx)
 	body, err := resp.Raw()
+	clients.observe(err)
+	if continueToken != "" && kube_errors.IsResourceExpired(err) {
+		return nil, "", fmt.Errorf("%w | %v", ErrContinueExpired, err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error returned from api server on %v | %w", req.URL().Path, err)
+		return nil, "", requestError("GET", req.URL().Path, fmt.Errorf("error returned from api server on %v | %w", req.URL().Path, err))
 	}
 
 	resTable := metav1.Table{}
 	err = json.Unmarshal(body, &resTable)
 	if err != nil {
-		return nil, fmt.Errorf("error wile unmarshaling data | %w", err)
+		return nil, "", fmt.Errorf("error wile unmarshaling data | %w", err)
 	}
 
 	nameColumnIdx, err := getColIndex("Name", &resTable.ColumnDefinitions)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse response data | %w", err)
+		return nil, "", fmt.Errorf("failed to parse response data | %w", err)
 	}
 
-	rv := make([]string, len(resTable.Rows))
+	rv := make([]string, 0, len(resTable.Rows))
 	for _, res := range resTable.Rows {
 		rv = append(rv, res.Cells[nameColumnIdx].(string))
 	}
-	return rv, nil
+	return rv, resTable.Continue, nil
 }
 
 func getColIndex(colName string, cols *[]metav1.TableColumnDefinition) (int, error) {
@@ -90,25 +123,30 @@ func getColIndex(colName string, cols *[]metav1.TableColumnDefinition) (int, err
 	return idx, nil
 }
 
-func GetUnstructured(ctx context.Context, contextName, name, group, version, resource, namespace string) ([]byte, error) {
-	cli := getK8sUnstructuredClient()
+// resourceClient returns a dynamic client for gvr within the given context.
+// If namespace is empty the client is cluster scoped.
+func resourceClient(contextName, namespace string, gvr schema.GroupVersionResource) (dynamic.ResourceInterface, *ContextClients, error) {
+	clients := ClientsFor(contextName)
+	cli, err := clients.Dynamic()
+	if err != nil {
+		return nil, nil, err
+	}
+	if namespace != "" {
+		return cli.Resource(gvr).Namespace(namespace), clients, nil
+	}
+	return cli.Resource(gvr), clients, nil
+}
 
+func GetUnstructured(ctx context.Context, contextName, name, group, version, resource, namespace string) ([]byte, error) {
 	gvr := schema.GroupVersionResource{
 		Group:    group,
 		Version:  version,
 		Resource: resource,
 	}
 
-	var rv *unstructured.Unstructured
-	var err error
-	opts := metav1.GetOptions{}
-	if namespace != "" {
-		rv, err = cli.Resource(gvr).Namespace(namespace).Get(ctx, name, opts)
-	} else {
-		rv, err = cli.Resource(gvr).Get(ctx, name, opts)
-	}
+	rv, err := GetUnstructuredRaw(ctx, contextName, name, namespace, &gvr)
 	if err != nil {
-		return nil, fmt.Errorf("error encountered while fetching resource definition | %w", err)
+		return nil, err
 	}
 
 	jsonRv, err := json.MarshalIndent(rv, "", "    ")
@@ -119,48 +157,177 @@ func GetUnstructured(ctx context.Context, contextName, name, group, version, res
 }
 
 func GetUnstructuredRaw(ctx context.Context, contextName, name, namespace string, gvr *schema.GroupVersionResource) (*unstructured.Unstructured, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
 	if gvr == nil {
 		return nil, fmt.Errorf("GetUnstructuredRaw passed nil gvr")
 	}
-	cli := getK8sUnstructuredClient()
-
+	cli, clients, err := resourceClient(contextName, namespace, *gvr)
+	if err != nil {
+		return nil, err
+	}
 
-	var rv *unstructured.Unstructured
-	var err error
-	opts := metav1.GetOptions{}
-	if namespace != "" {
-		rv, err = cli.Resource(*gvr).Namespace(namespace).Get(ctx, name, opts)
-	} else {
-		rv, err = cli.Resource(*gvr).Get(ctx, name, opts)
+	rv, err := cli.Get(ctx, name, metav1.GetOptions{})
+	clients.observe(err)
+	if kube_errors.IsNotFound(err) {
+		return nil, ErrNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("error encountered while fetching resource definition | %w", err)
+		return nil, fmt.Errorf("error encountered while fetching resource definition | %w", requestError("GET", apiPath(*gvr, namespace, name), err))
 	}
 
 	return rv, nil
 }
 
+// WriteUnstructured updates obj. With dryRun set the API server validates
+// and defaults the update, and returns the result, without persisting it.
 func WriteUnstructured(
 	ctx context.Context, contextName, name, namespace string,
 	gvr *schema.GroupVersionResource,
 	obj *unstructured.Unstructured,
+	dryRun bool,
 ) (*unstructured.Unstructured, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
 	if gvr == nil {
 		return nil, fmt.Errorf("WriteUnstructured passed nil gvr")
 	}
 
-	cli := getK8sUnstructuredClient()
+	cli, clients, err := resourceClient(contextName, namespace, *gvr)
+	if err != nil {
+		return nil, err
+	}
 	opts := metav1.UpdateOptions{
 		FieldValidation: "Strict",
 	}
-	var err error
-	var rv *unstructured.Unstructured
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
 
-	if namespace != "" {
-		rv, err = cli.Resource(*gvr).Namespace(namespace).Update(ctx, obj, opts)
-	} else {
-		rv, err = cli.Resource(*gvr).Update(ctx, obj, opts)
+	rv, err := cli.Update(ctx, obj, opts)
+	clients.observe(err)
+	return rv, requestError("PUT", apiPath(*gvr, namespace, name), err)
+}
+
+// ApplyUnstructured server-side applies obj as fieldManager. With force set,
+// fields owned by other managers are taken over rather than conflicting. With
+// dryRun set the result is returned without being persisted.
+func ApplyUnstructured(
+	ctx context.Context, contextName, namespace string,
+	gvr *schema.GroupVersionResource,
+	obj *unstructured.Unstructured,
+	fieldManager string, force, dryRun bool,
+) (*unstructured.Unstructured, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
+	if gvr == nil {
+		return nil, fmt.Errorf("ApplyUnstructured passed nil gvr")
+	}
+
+	cli, clients, err := resourceClient(contextName, namespace, *gvr)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("error encountered while marshalling object to apply | %w", err)
+	}
+	opts := metav1.PatchOptions{
+		FieldManager: fieldManager,
+		Force:        &force,
+	}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+
+	rv, err := cli.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, opts)
+	clients.observe(err)
+	return rv, requestError("PATCH", apiPath(*gvr, namespace, obj.GetName()), err)
+}
+
+// ApplyConflicts describes the fields of a failed apply which are owned by
+// other field managers, one per line, or returns "" if err isn't an apply
+// conflict.
+func ApplyConflicts(err error) string {
+	var status kube_errors.APIStatus
+	if !errors.As(err, &status) || !kube_errors.IsConflict(err) {
+		return ""
+	}
+	details := status.Status().Details
+	if details == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, cause := range details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		// The message names the owning manager, e.g.
+		// `conflict with "kube-controller-manager" using apps/v1`.
+		fmt.Fprintf(&b, "%v: %v\n", cause.Field, cause.Message)
+	}
+	return b.String()
+}
+
+// CreateUnstructured creates obj, which must not already exist.
+func CreateUnstructured(
+	ctx context.Context, contextName, namespace string,
+	gvr *schema.GroupVersionResource,
+	obj *unstructured.Unstructured,
+	fieldManager string,
+) (*unstructured.Unstructured, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
+	if gvr == nil {
+		return nil, fmt.Errorf("CreateUnstructured passed nil gvr")
+	}
+
+	cli, clients, err := resourceClient(contextName, namespace, *gvr)
+	if err != nil {
+		return nil, err
+	}
+	opts := metav1.CreateOptions{
+		FieldManager:    fieldManager,
+		FieldValidation: "Strict",
+	}
+
+	rv, err := cli.Create(ctx, obj, opts)
+	clients.observe(err)
+	return rv, requestError("POST", apiPath(*gvr, namespace, ""), err)
+}
+
+// DeleteUnstructured deletes the named object. A nil gracePeriodSeconds and an
+// empty propagation leave the choice to the API server.
+func DeleteUnstructured(
+	ctx context.Context, contextName, name, namespace string,
+	gvr *schema.GroupVersionResource,
+	gracePeriodSeconds *int64, propagation string,
+) error {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
+	if gvr == nil {
+		return fmt.Errorf("DeleteUnstructured passed nil gvr")
+	}
+
+	cli, clients, err := resourceClient(contextName, namespace, *gvr)
+	if err != nil {
+		return err
+	}
+	opts := metav1.DeleteOptions{
+		GracePeriodSeconds: gracePeriodSeconds,
+	}
+	if propagation != "" {
+		policy := metav1.DeletionPropagation(propagation)
+		opts.PropagationPolicy = &policy
 	}
 
-	return rv, err
+	err = cli.Delete(ctx, name, opts)
+	clients.observe(err)
+	return requestError("DELETE", apiPath(*gvr, namespace, name), err)
 }
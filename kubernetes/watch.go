@@ -0,0 +1,354 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchSyncTimeout bounds how long the first access to a watch waits for the
+// initial LIST to complete before giving up and falling back to a plain LIST.
+const watchSyncTimeout = 30 * time.Second
+
+type WatchEventType string
+
+const (
+	WatchAdded    WatchEventType = "ADDED"
+	WatchModified WatchEventType = "MODIFIED"
+	WatchDeleted  WatchEventType = "DELETED"
+)
+
+// WatchEvent describes a change to a single object seen by a Watch.
+type WatchEvent struct {
+	Type   WatchEventType
+	Name   string
+	Object *unstructured.Unstructured
+}
+
+type watchKey struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
+// Watch is an informer backed cache of every object of one resource in one
+// namespace, or the whole cluster if namespace is empty. Watches are started
+// on first access and stopped once unused for ClientOptions.WatchIdleTimeout.
+type Watch struct {
+	key      watchKey
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+
+	mu          sync.Mutex
+	stopped     bool
+	lastUsed    time.Time
+	holds       int
+	subscribers map[int]func(WatchEvent)
+	nextID      int
+}
+
+func newWatch(cli dynamic.Interface, key watchKey) *Watch {
+	w := &Watch{
+		key:         key,
+		stop:        make(chan struct{}),
+		lastUsed:    time.Now(),
+		subscribers: make(map[int]func(WatchEvent)),
+	}
+	w.informer = dynamicinformer.NewFilteredDynamicInformer(
+		cli, key.gvr, key.namespace, 0, cache.Indexers{}, nil,
+	).Informer()
+	w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			w.dispatch(WatchAdded, obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			w.dispatch(WatchModified, obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			w.dispatch(WatchDeleted, obj)
+		},
+	})
+
+	go w.informer.Run(w.stop)
+	go func() {
+		select {
+		case <-shutdownCtx.Done():
+			w.Stop()
+		case <-w.stop:
+		}
+	}()
+	return w
+}
+
+func (w *Watch) String() string {
+	if w.key.namespace == "" {
+		return w.key.gvr.String()
+	}
+	return fmt.Sprintf("%v in %v", w.key.gvr.String(), w.key.namespace)
+}
+
+// Stop stops the informer. A stopped watch keeps serving its last contents.
+func (w *Watch) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.stopped {
+		w.stopped = true
+		close(w.stop)
+	}
+}
+
+// Stopped reports whether the watch was stopped, after which its contents
+// will no longer be updated.
+func (w *Watch) Stopped() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stopped
+}
+
+func (w *Watch) touch() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastUsed = time.Now()
+}
+
+// Hold keeps the watch from being stopped for idleness until the returned
+// func is called, for instance while a directory it serves is open.
+func (w *Watch) Hold() func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.holds++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.holds--
+			w.lastUsed = time.Now()
+		})
+	}
+}
+
+// idle reports whether the watch has been unused and unheld for longer than
+// timeout.
+func (w *Watch) idle(timeout time.Duration) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.holds == 0 && time.Since(w.lastUsed) > timeout
+}
+
+func (w *Watch) waitForSync(ctx context.Context) error {
+	if w.informer.HasSynced() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, watchSyncTimeout)
+	defer cancel()
+
+	stopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-w.stop:
+		}
+		close(stopCh)
+	}()
+	if !cache.WaitForCacheSync(stopCh, w.informer.HasSynced) {
+		return fmt.Errorf("watch of %v failed to sync", w)
+	}
+	return nil
+}
+
+// Names returns the sorted names of every object in the cache.
+func (w *Watch) Names() []string {
+	w.touch()
+	objs := w.informer.GetStore().List()
+	rv := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		rv = append(rv, u.GetName())
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// Get returns a copy of the named object from the cache.
+func (w *Watch) Get(name string) (*unstructured.Unstructured, bool) {
+	w.touch()
+	key := name
+	if w.key.namespace != "" {
+		key = w.key.namespace + "/" + name
+	}
+	obj, exists, err := w.informer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return nil, false
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, false
+	}
+	return u.DeepCopy(), true
+}
+
+// Subscribe calls fn for every change seen once the cache has synced. The
+// returned func removes the subscription.
+func (w *Watch) Subscribe(fn func(WatchEvent)) func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	id := w.nextID
+	w.nextID++
+	w.subscribers[id] = fn
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subscribers, id)
+	}
+}
+
+func (w *Watch) dispatch(t WatchEventType, obj interface{}) {
+	// The initial LIST is reported as a stream of adds, which nobody
+	// needs to hear about.
+	if !w.informer.HasSynced() {
+		return
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	ev := WatchEvent{Type: t, Name: u.GetName(), Object: u}
+
+	w.mu.Lock()
+	subscribers := make([]func(WatchEvent), 0, len(w.subscribers))
+	for _, fn := range w.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	w.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(ev)
+	}
+}
+
+// Watch returns the running watch of gvr in namespace, starting it if needed,
+// once its cache has synced.
+func (c *ContextClients) Watch(ctx context.Context, gvr schema.GroupVersionResource, namespace string) (*Watch, error) {
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+
+	cli, err := c.watchClient()
+	if err != nil {
+		return nil, err
+	}
+
+	key := watchKey{gvr: gvr, namespace: namespace}
+	c.mu.Lock()
+	if c.watches == nil {
+		c.watches = make(map[watchKey]*Watch)
+	}
+	w, exists := c.watches[key]
+	if !exists || w.Stopped() {
+		w = newWatch(cli, key)
+		c.watches[key] = w
+		if !c.reaping {
+			c.reaping = true
+			go c.reapWatches()
+		}
+	}
+	c.mu.Unlock()
+
+	w.touch()
+	err = w.waitForSync(ctx)
+	if err != nil {
+		c.dropWatch(w)
+		return nil, err
+	}
+	return w, nil
+}
+
+// watchClient returns a dynamic client without a request timeout, since a
+// watch is a single long running request.
+func (c *ContextClients) watchClient() (dynamic.Interface, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	config, err := c.ensureConfig()
+	if err != nil {
+		return nil, err
+	}
+	if c.watchDynamic == nil {
+		config = rest.CopyConfig(config)
+		config.Timeout = 0
+		c.watchDynamic, err = dynamic.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make dynamic client from k8s config | %w", err)
+		}
+	}
+	return c.watchDynamic, nil
+}
+
+func (c *ContextClients) dropWatch(w *Watch) {
+	w.Stop()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.watches[w.key] == w {
+		delete(c.watches, w.key)
+	}
+}
+
+// stopWatches stops every watch of this context. Callers must hold c.mu.
+func (c *ContextClients) stopWatches() {
+	for key, w := range c.watches {
+		w.Stop()
+		delete(c.watches, key)
+	}
+}
+
+// reapWatches stops watches which have been idle for too long. It exits once
+// there are no watches left.
+func (c *ContextClients) reapWatches() {
+	interval := clientOptions.WatchIdleTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdownCtx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		for key, w := range c.watches {
+			if w.Stopped() || w.idle(clientOptions.WatchIdleTimeout) {
+				log.Printf("Stopping idle watch of %v", w)
+				w.Stop()
+				delete(c.watches, key)
+			}
+		}
+		if len(c.watches) == 0 {
+			c.reaping = false
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+	}
+}
@@ -1,52 +1,307 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
-	"k8s.io/client-go/kubernetes"
 
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
 	"rorycrispin.co.uk/kubefs/resources"
 )
 
-var cli *kubernetes.Clientset
+// stringList is a flag.Value which may be repeated, or given a comma
+// separated list.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	for _, part := range strings.Split(v, ",") {
+		if part != "" {
+			*s = append(*s, part)
+		}
+	}
+	return nil
+}
+
+type flagValues struct {
+	configPath        string
+	kubeconfig        string
+	contexts          stringList
+	readOnly          bool
+	allowOther        bool
+	debug             bool
+	apiResourceTTL    time.Duration
+	clientTimeout     time.Duration
+	entryTimeout      time.Duration
+	attrTimeout       time.Duration
+	shutdownTimeout   time.Duration
+	cacheMaxEntries   int
+	cacheMaxBytes     int64
+	listPageSize      int64
+	fieldManager      string
+	applyForce        bool
+	deleteContexts    stringList
+	execContexts      stringList
+	deleteGracePeriod int64
+	policyFile        string
+	readWrite         bool
+	auditLog          string
+	deletePropagation string
+	watchCache        bool
+	watchIdleTimeout  time.Duration
+	notify            bool
+	qps               float64
+	burst             int
+	credentialCheck   time.Duration
+	daemon            bool
+	pidFile           string
+	logFile           string
+	controlSocket     string
+}
+
+func parseFlags(args []string) (*config.Config, error) {
+	defaults := config.Default()
+	v := flagValues{}
+
+	flags := flag.NewFlagSet("kubefs", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: kubefs [flags] [mountpoint]\n\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&v.configPath, "config", config.DefaultPath(), "path to a YAML config file")
+	flags.StringVar(&v.kubeconfig, "kubeconfig", "", "path to a kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config")
+	flags.Var(&v.contexts, "context", "only expose contexts matching this glob, may be repeated")
+	flags.BoolVar(&v.readOnly, "read-only", false, "mount the filesystem read-only")
+	flags.BoolVar(&v.readWrite, "read-write", false, "change objects without requiring a .unlock first")
+	flags.StringVar(&v.auditLog, "audit-log", "", "file to record unlocks and changes to clusters in, defaults to the log")
+	flags.BoolVar(&v.allowOther, "allow-other", false, "allow other users to access the mount")
+	flags.BoolVar(&v.debug, "debug", false, "log every FUSE operation")
+	flags.DurationVar(&v.apiResourceTTL, "api-resource-ttl", defaults.APIResourceTTL.Duration, "how long discovered API resources are cached")
+	flags.DurationVar(&v.clientTimeout, "client-timeout", defaults.ClientTimeout.Duration, "timeout for requests to the API server")
+	flags.DurationVar(&v.entryTimeout, "entry-timeout", defaults.EntryTimeout.Duration, "how long the kernel caches directory entries")
+	flags.DurationVar(&v.attrTimeout, "attr-timeout", defaults.AttrTimeout.Duration, "how long the kernel caches file attributes")
+	flags.IntVar(&v.cacheMaxEntries, "cache-max-entries", defaults.CacheMaxEntries, "maximum number of cached entries, 0 for no limit")
+	flags.Int64Var(&v.cacheMaxBytes, "cache-max-bytes", defaults.CacheMaxBytes, "approximate maximum size of the cache in bytes, 0 for no limit")
+	flags.Int64Var(&v.listPageSize, "list-page-size", defaults.ListPageSize, "objects fetched per request when listing a directory, 0 for all at once")
+	flags.StringVar(&v.fieldManager, "field-manager", defaults.FieldManager, "field manager to server-side apply as")
+	flags.BoolVar(&v.applyForce, "apply-force", false, "take ownership of conflicting fields when applying")
+	flags.Var(&v.deleteContexts, "delete-context", "allow deleting objects in contexts matching this glob, may be repeated")
+	flags.Var(&v.execContexts, "exec-context", "allow exec in containers in contexts matching this glob, may be repeated")
+	flags.StringVar(&v.policyFile, "policy", "", "path to a policy file, defaults to ~/.config/kubefs/policy.yaml if it exists")
+	flags.Int64Var(&v.deleteGracePeriod, "delete-grace-period", 0, "grace period in seconds for deletions, defaults to the object's own")
+	flags.StringVar(&v.deletePropagation, "delete-propagation", "", "propagation policy for deletions: Foreground, Background or Orphan")
+	flags.BoolVar(&v.watchCache, "watch-cache", false, "serve listings from watches instead of listing on every readdir")
+	flags.DurationVar(&v.watchIdleTimeout, "watch-idle-timeout", defaults.WatchIdleTimeout.Duration, "how long an unused watch is kept running")
+	flags.BoolVar(&v.notify, "notify", false, "watch resource directories while the kernel holds them, so inotify hears of changes")
+	flags.Float64Var(&v.qps, "qps", float64(defaults.QPS), "requests per second allowed to each context")
+	flags.IntVar(&v.burst, "burst", defaults.Burst, "burst of requests allowed to each context")
+	flags.DurationVar(&v.credentialCheck, "credential-check", defaults.CredentialCheck.Duration, "how often the kubeconfig is re-read for rotated credentials")
+	flags.DurationVar(&v.shutdownTimeout, "shutdown-timeout", defaults.ShutdownTimeout.Duration, "how long pending edits may take to flush on exit")
+	flags.BoolVar(&v.daemon, "daemon", false, "detach from the terminal and run in the background")
+	flags.StringVar(&v.pidFile, "pidfile", "", "pidfile to write in daemon mode")
+	flags.StringVar(&v.logFile, "log-file", "", "file to log to in daemon mode")
+	flags.StringVar(&v.controlSocket, "control-socket", "", "unix socket to accept control commands on, see 'kubefs ctl'")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 1 {
+		return nil, fmt.Errorf("expected at most one mountpoint, got %v", flags.Args())
+	}
+
+	// Only insist that the config file exists if it was asked for explicitly.
+	explicitConfig := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicitConfig = true
+		}
+	})
+	cfg, err := config.Load(v.configPath, explicitConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Flags which were set on the command line take precedence over the file.
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "kubeconfig":
+			cfg.Kubeconfig = v.kubeconfig
+		case "context":
+			cfg.Contexts = v.contexts
+		case "read-only":
+			cfg.ReadOnly = v.readOnly
+		case "read-write":
+			cfg.ReadWrite = v.readWrite
+		case "audit-log":
+			cfg.AuditLog = v.auditLog
+		case "allow-other":
+			cfg.AllowOther = v.allowOther
+		case "debug":
+			cfg.Debug = v.debug
+		case "api-resource-ttl":
+			cfg.APIResourceTTL.Duration = v.apiResourceTTL
+		case "client-timeout":
+			cfg.ClientTimeout.Duration = v.clientTimeout
+		case "entry-timeout":
+			cfg.EntryTimeout.Duration = v.entryTimeout
+		case "attr-timeout":
+			cfg.AttrTimeout.Duration = v.attrTimeout
+		case "cache-max-entries":
+			cfg.CacheMaxEntries = v.cacheMaxEntries
+		case "cache-max-bytes":
+			cfg.CacheMaxBytes = v.cacheMaxBytes
+		case "list-page-size":
+			cfg.ListPageSize = v.listPageSize
+		case "field-manager":
+			cfg.FieldManager = v.fieldManager
+		case "apply-force":
+			cfg.ApplyForce = v.applyForce
+		case "delete-context":
+			cfg.DeleteContexts = v.deleteContexts
+		case "exec-context":
+			cfg.ExecContexts = v.execContexts
+		case "policy":
+			cfg.PolicyFile = v.policyFile
+		case "delete-grace-period":
+			cfg.DeleteGracePeriodSeconds = &v.deleteGracePeriod
+		case "delete-propagation":
+			cfg.DeletePropagation = v.deletePropagation
+		case "watch-cache":
+			cfg.WatchCache = v.watchCache
+		case "watch-idle-timeout":
+			cfg.WatchIdleTimeout.Duration = v.watchIdleTimeout
+		case "notify":
+			cfg.Notify = v.notify
+		case "qps":
+			cfg.QPS = float32(v.qps)
+		case "burst":
+			cfg.Burst = v.burst
+		case "credential-check":
+			cfg.CredentialCheck.Duration = v.credentialCheck
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout.Duration = v.shutdownTimeout
+		case "daemon":
+			cfg.Daemon = v.daemon
+		case "pidfile":
+			cfg.PidFile = v.pidFile
+		case "log-file":
+			cfg.LogFile = v.logFile
+		case "control-socket":
+			cfg.ControlSocket = v.controlSocket
+		}
+	})
+	if flags.NArg() == 1 {
+		cfg.MountPoint = flags.Arg(0)
+	}
+	if cfg.Daemon {
+		cfg.ApplyDaemonDefaults()
+	}
+	err = cfg.LoadPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, cfg.Validate()
+}
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(runCtl(os.Args[2:]))
+	}
+
+	cfg, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("kubefs: %v", err)
+	}
+
+	if cfg.Daemon && !isDaemonChild() {
+		os.Exit(daemonize(cfg))
+	}
+	os.Exit(run(cfg))
+}
+
+// run mounts the filesystem and serves it until it is unmounted, returning
+// the status to exit with.
+func run(cfg *config.Config) int {
+	if cfg.Daemon {
+		err := writePidFile(cfg.PidFile)
+		if err != nil {
+			log.Printf("Failed to write pidfile | %v", err)
+			return exitPidFileFailed
+		}
+		defer os.Remove(cfg.PidFile)
+	}
 
-	mntDir, err := ioutil.TempDir("", "xoyo")
-	mntDir = "/tmp/kubefs"
+	err := recoverStaleMount(cfg.MountPoint)
 	if err != nil {
-		panic(fmt.Errorf("Failed to mount | %w", err))
-	}
-
-	// cli, err := kube.GetK8sDiscoveryClient("eng-instances")
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// kube.GetApiResources(cli)
-
-	// _, err = kube.ListResourceNames(context.TODO(), "cert-manager.io", "v1", "certificates", "eng-instances", "eng-dev")
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	root := resources.NewRootContextNode()
-	server, err := fs.Mount(mntDir, root, &fs.Options{
-		MountOptions: fuse.MountOptions{
-			Debug: true,
-			AllowOther: false,
-		},
+		log.Fatalf("Failed to recover stale mount | %v", err)
+	}
+	err = os.MkdirAll(cfg.MountPoint, 0o755)
+	if err != nil {
+		log.Fatalf("Failed to create mountpoint | %v", err)
+	}
+
+	kube.Configure(kube.ClientOptions{
+		Kubeconfig:       cfg.Kubeconfig,
+		Timeout:          cfg.ClientTimeout.Duration,
+		QPS:              cfg.QPS,
+		Burst:            cfg.Burst,
+		CredentialCheck:  cfg.CredentialCheck.Duration,
+		WatchIdleTimeout: cfg.WatchIdleTimeout.Duration,
+	})
+
+	mountOpts := fuse.MountOptions{
+		Debug:      cfg.Debug,
+		AllowOther: cfg.AllowOther,
+		FsName:     "kubefs",
+		Name:       "kubefs",
+	}
+	if cfg.ReadOnly {
+		mountOpts.Options = append(mountOpts.Options, "ro")
+	}
+
+	entryTimeout, attrTimeout := cfg.KernelTimeouts()
+	root := resources.NewRootContextNode(cfg)
+	server, err := fs.Mount(cfg.MountPoint, root, &fs.Options{
+		MountOptions: mountOpts,
+		EntryTimeout: &entryTimeout,
+		AttrTimeout:  &attrTimeout,
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	log.Printf("Mounted on %s", mntDir)
-	log.Printf("Unmount by calling 'fusermount -u %s'", mntDir)
+	log.Printf("Mounted on %s", cfg.MountPoint)
+	log.Printf("Unmount by calling 'fusermount -u %s'", cfg.MountPoint)
+
+	unmountRequests := make(chan struct{}, 1)
+	if cfg.ControlSocket != "" {
+		ctl, err := listenControl(cfg, root, unmountRequests)
+		if err != nil {
+			log.Printf("Failed to start control socket | %v", err)
+			server.Unmount()
+			return exitUnmountFailed
+		}
+		defer ctl.Close()
+		log.Printf("Accepting control commands on %s", cfg.ControlSocket)
+	}
+	if cfg.Daemon {
+		notifyDaemonParent()
+	}
 
-	// Wait until unmount before exiting
-	server.Wait()
+	return serve(server, cfg, unmountRequests)
 }
@@ -2,8 +2,10 @@ package resources
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -11,8 +13,8 @@ import (
 	"github.com/hanwen/go-fuse/v2/fuse"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	k8s "k8s.io/client-go/kubernetes"
 
+	"rorycrispin.co.uk/kubefs/config"
 	kube "rorycrispin.co.uk/kubefs/kubernetes"
 )
 
@@ -25,6 +27,7 @@ type ResourceTypeNode struct {
 	contextName string
 
 	stateStore *State
+	config     *config.Config
 }
 
 func (n *ResourceTypeNode) Path() string {
@@ -58,6 +61,7 @@ func (n *ResourceTypeNode) Lookup(ctx context.Context, name string, out *fuse.En
 			contextName: n.contextName,
 
 			stateStore: n.stateStore,
+			config:     n.config,
 		},
 		fs.StableAttr{
 			Mode: syscall.S_IFDIR,
@@ -77,11 +81,19 @@ type RootResourcesNode struct {
 	namespaced  bool
 
 	stateStore *State
-	lastError        error
+	config     *config.Config
 }
 
 func (n *RootResourcesNode) Path() string {
-	return fmt.Sprintf("%v/resources", n.contextName)
+	if n.namespaced {
+		return fmt.Sprintf("%v/resources/namespaced", n.contextName)
+	}
+	return fmt.Sprintf("%v/resources/cluster", n.contextName)
+}
+
+// recordedErrors are the failures to discover the context's API resources.
+func (n *RootResourcesNode) recordedErrors() dirErrors {
+	return dirErrors{n.stateStore, n.Path()}
 }
 
 type APIResources map[string]*GroupedAPIResource
@@ -93,6 +105,8 @@ type GroupedAPIResource struct {
 	Namespaced   bool
 	Group string
 	Version string
+	Kind         string
+	Verbs        []string
 }
 
 func (g *GroupedAPIResource) CLIName() string {
@@ -109,6 +123,16 @@ func (g *GroupedAPIResource) GroupVersion() string {
 	return fmt.Sprint(g.Group, "/", g.Version)
 }
 
+// Watchable reports whether the resource can be served from a watch.
+func (g *GroupedAPIResource) Watchable() bool {
+	var list, watch bool
+	for _, verb := range g.Verbs {
+		list = list || verb == "list"
+		watch = watch || verb == "watch"
+	}
+	return list && watch
+}
+
 func (g *GroupedAPIResource) GVR() *schema.GroupVersionResource {
 	return &schema.GroupVersionResource{
 		Group: g.Group,
@@ -117,66 +141,87 @@ func (g *GroupedAPIResource) GVR() *schema.GroupVersionResource {
 	}
 }
 
-func ensureAPIResources(stateStore *State, contextName string) (APIResources, error) {
+// Size estimates the memory held by the resources, for the State's memory cap.
+func (r APIResources) Size() int64 {
+	var size int64
+	for name, res := range r {
+		size += int64(len(name)+len(res.ResourceName)+len(res.Group)+len(res.Version)) + 64
+		for _, short := range res.ShortNames {
+			size += int64(len(short))
+		}
+	}
+	return size
+}
+
+// ensureAPIResources returns the API resources of the context, from the cache
+// if possible. Concurrent callers share a single discovery call.
+func ensureAPIResources(stateStore *State, cfg *config.Config, contextName string) (APIResources, error) {
 	stateKey := fmt.Sprintf("%v/api-resources", contextName)
+	elem, err := stateStore.GetOrLoad(stateKey, cfg.APIResourceTTL.Duration, func() (any, error) {
+		return loadAPIResources(contextName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	rv, ok := elem.(APIResources)
+	if !ok {
+		panic("failed type assertion")
+	}
+	return rv, nil
+}
+
+func loadAPIResources(contextName string) (APIResources, error) {
 	rv := make(APIResources)
-	elem, exist := stateStore.Get(stateKey)
-	if exist {
-		var ok bool
-		rv, ok = elem.(APIResources)
-		if !ok {
-			panic("failed type assertion")
-		}
-		fmt.Printf("Using cached copy of API-Resources\n")
-	} else {
-		cli, err := kube.GetK8sDiscoveryClient(contextName)
+	cli, err := kube.ClientsFor(contextName).Discovery()
+	if err != nil {
+		return nil, err
+	}
 
-		resp, err := kube.GetApiResources(cli)
-		if err != nil {
-			return nil, fmt.Errorf("err getting resources | %w", err)
-		}
-		var workingResource *GroupedAPIResource
-		var a *metav1.APIResource
-		var i int
-		for _, grp := range *resp {
-			for i = range grp.APIResources {
-				a = &grp.APIResources[i]
-
-				group, version, err := splitGroupVersion(grp.GroupVersion)
-				if err != nil {
-					return nil, err
-				}
-				workingResource = &GroupedAPIResource{
-					ResourceName: a.Name,
-					Group: group,
-					Version: version,
-					ShortNames:   a.ShortNames,
-					Namespaced:   a.Namespaced,
-				}
-
-				elem, exists := rv[workingResource.CLIName()]
-				if exists {
-					// TODO DEV this should not get hit, reduce it from a panic later.
-					panic(fmt.Errorf("Found collision between %v/%v and %v/%v\n", grp.GroupVersion, a.Name, elem.GroupVersion, elem.ResourceName))
-				}
-
-				rv[workingResource.CLIName()] = workingResource
+	resp, err := kube.GetApiResources(cli)
+	if err != nil {
+		return nil, fmt.Errorf("err getting resources | %w", err)
+	}
+	var workingResource *GroupedAPIResource
+	var a *metav1.APIResource
+	var i int
+	for _, grp := range *resp {
+		for i = range grp.APIResources {
+			a = &grp.APIResources[i]
+
+			group, version, err := splitGroupVersion(grp.GroupVersion)
+			if err != nil {
+				return nil, err
+			}
+			workingResource = &GroupedAPIResource{
+				ResourceName: a.Name,
+				Group: group,
+				Version: version,
+				ShortNames:   a.ShortNames,
+				Namespaced:   a.Namespaced,
+				Kind:         a.Kind,
+				Verbs:        a.Verbs,
+			}
+
+			elem, exists := rv[workingResource.CLIName()]
+			if exists {
+				// TODO DEV this should not get hit, reduce it from a panic later.
+				panic(fmt.Errorf("Found collision between %v/%v and %v/%v\n", grp.GroupVersion, a.Name, elem.GroupVersion(), elem.ResourceName))
 			}
 
+			rv[workingResource.CLIName()] = workingResource
 		}
-		// TODO make this TTL configurable
-		stateStore.PutTTL(stateKey, rv, 1 * time.Minute)
+
 	}
 	return rv, nil
 }
 
 func (n *RootResourcesNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	resources, err := ensureAPIResources(n.stateStore, n.contextName)
+	resources, err := ensureAPIResources(n.stateStore, n.config, n.contextName)
 	if err != nil {
-		n.lastError = fmt.Errorf("error while getting API resources | %w", err)
+		n.recordedErrors().record(fmt.Errorf("error while getting API resources | %w", err))
 		return readDirErrResponse(n.Path())
 	}
-	entries := make([]fuse.DirEntry, 0, len(resources))
+	entries := make([]fuse.DirEntry, 0, len(resources)+1)
 	for _, res := range resources {
 		if res.Namespaced != n.namespaced {
 			continue
@@ -187,14 +232,17 @@ func (n *RootResourcesNode) Readdir(ctx context.Context) (fs.DirStream, syscall.
 			Mode: syscall.S_IFREG,
 		})
 	}
-	return fs.NewListDirStream(entries), 0
+	return fs.NewListDirStream(n.recordedErrors().withEntry(entries)), 0
 }
 
 func (n *RootResourcesNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
-	resources, err := ensureAPIResources(n.stateStore, n.contextName)
+	if name == "error" {
+		return n.recordedErrors().lookup(ctx, &n.Inode, n.config)
+	}
+	resources, err := ensureAPIResources(n.stateStore, n.config, n.contextName)
 	if err != nil {
-		fmt.Printf("Error while looking up Resource %v | %v", name, err)
-		return nil, syscall.ENOENT
+		n.recordedErrors().record(fmt.Errorf("error while looking up resource %v | %w", name, err))
+		return nil, errnoFor(err)
 	}
 	elem, exists := resources[name]
 	if !exists {
@@ -205,6 +253,7 @@ func (n *RootResourcesNode) Lookup(ctx context.Context, name string, out *fuse.E
 			contextName:  n.contextName,
 			groupVersion: elem,
 			stateStore:   n.stateStore,
+			config:       n.config,
 		},
 			fs.StableAttr{
 				Mode: syscall.S_IFDIR,
@@ -217,6 +266,7 @@ func (n *RootResourcesNode) Lookup(ctx context.Context, name string, out *fuse.E
 			contextName:  n.contextName,
 			groupVersion: elem,
 			stateStore:   n.stateStore,
+			config:       n.config,
 		},
 			fs.StableAttr{
 				Mode: syscall.S_IFDIR,
@@ -227,6 +277,16 @@ func (n *RootResourcesNode) Lookup(ctx context.Context, name string, out *fuse.E
 	}
 }
 
+var _ = (fs.NodeUnlinker)((*RootResourcesNode)(nil))
+
+// Unlink dismisses the error file.
+func (n *RootResourcesNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	if name != "error" {
+		return syscall.EPERM
+	}
+	return n.recordedErrors().dismiss()
+}
+
 // APIResourceNode is a dir containing the list of resources for an API
 // resource. It may be Namespaced or Clustered. If the resource is clustered,
 // then the namespace field will be the empty string.
@@ -241,73 +301,230 @@ type APIResourceNode struct {
 	// groupVersion.Namespaced should also be set correctly.
 	namespace string
 
-	lastError error
+	// watch is the watch this node is subscribed to when the watch cache
+	// is enabled, so that changes invalidate the kernel's entries.
+	mu          sync.Mutex
+	watch       *kube.Watch
+	unsubscribe func()
 
-	cli        *k8s.Clientset
 	stateStore *State
+	config     *config.Config
 }
 
 func (n *APIResourceNode) Path() string {
 	if n.groupVersion.Namespaced {
 		return fmt.Sprintf("%v/resources/%v/%v/namespaces/%v",
-			n.contextName, n.groupVersion.GroupVersion, n.groupVersion.ResourceName, n.namespace,
+			n.contextName, n.groupVersion.GroupVersion(), n.groupVersion.ResourceName, n.namespace,
 		)
 	} else {
 		return fmt.Sprintf("%v/resources/%v/%v", //TODO is this shadowed?
-			n.contextName, n.groupVersion.GroupVersion, n.groupVersion.ResourceName,
+			n.contextName, n.groupVersion.GroupVersion(), n.groupVersion.ResourceName,
 		)
 	}
 }
 
 var _ = (fs.NodeReaddirer)((*APIResourceNode)(nil))
 
-func (n *APIResourceNode) ensureClientSet() error {
-	if n.cli != nil {
-		return nil
+func (n *APIResourceNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	w := n.cachedWatch(ctx)
+	if w == nil {
+		return n.readdirPaged(ctx)
 	}
-	cli, err := kube.GetK8sClient(n.contextName)
-	if err != nil {
-		return err
+
+	results := w.Names()
+	entries := make([]fuse.DirEntry, 0, len(results)+2)
+	entries = append(entries, n.extraEntries()...)
+	for _, p := range results {
+		entries = append(entries, n.objectEntry(p))
 	}
-	n.cli = cli
-	return nil
+	// Keep watching for as long as the directory is open, so that inotify
+	// users hear about deletions.
+	return &heldDirStream{
+		DirStream: fs.NewListDirStream(entries),
+		release:   w.Hold(),
+	}, 0
 }
 
-func (n *APIResourceNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	err := n.ensureClientSet()
-	if err != nil {
-		panic(err)
+func (n *APIResourceNode) objectEntry(name string) fuse.DirEntry {
+	return fuse.DirEntry{
+		Name: name,
+		Ino:  hash(fmt.Sprintf("%v/%v", n.Path(), name)),
+		Mode: fuse.S_IFDIR,
 	}
-	results, err := kube.ListResourceNames(ctx, n.groupVersion.GroupVersion(), n.groupVersion.ResourceName, n.contextName, n.namespace)
+}
+
+// readdirPaged lists the directory from the API server a page at a time. The
+// first page is fetched now, so that failing to list at all is reported as
+// before; later pages are fetched as the kernel reads on.
+func (n *APIResourceNode) readdirPaged(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	names, next, err := kube.ListResourceNamesPage(
+		ctx, n.groupVersion.GroupVersion(), n.groupVersion.ResourceName, n.contextName, n.namespace,
+		n.config.ListPageSize, "",
+	)
 	if err != nil {
 		// The filesystem is our interface with the user, so let
 		// errors here be exposed via said interface.
-		n.lastError = err
+		n.recordedErrors().record(err)
 		return readDirErrResponse(n.Path())
 	}
+	return &pagedDirStream{
+		node:  n,
+		extra: n.extraEntries(),
+		page:  names,
+		next:  next,
+	}, 0
+}
 
-	entries := make([]fuse.DirEntry, 0, len(results))
-	for _, p := range results {
-		if p == "" {
-			continue
+// applyEntry is the directory's apply.yaml, for applying manifests of this
+// resource to the namespace.
+func (n *APIResourceNode) applyEntry() fuse.DirEntry {
+	return fuse.DirEntry{
+		Name: "apply.yaml",
+		Ino:  hash(fmt.Sprintf("%v/apply.yaml", n.Path())),
+		Mode: fuse.S_IFREG,
+	}
+}
+
+// extraEntries are the files listed alongside the directory's objects.
+func (n *APIResourceNode) extraEntries() []fuse.DirEntry {
+	entries := []fuse.DirEntry{n.applyEntry(), unlockEntry(n.Path())}
+	return n.recordedErrors().withEntry(entries)
+}
+
+// recordedErrors are the failures to list the directory, and to create and
+// delete objects within it.
+func (n *APIResourceNode) recordedErrors() dirErrors {
+	return dirErrors{n.stateStore, n.Path()}
+}
+
+var _ = (fs.NodeUnlinker)((*APIResourceNode)(nil))
+
+// Unlink dismisses the error file, or locks the directory by removing its
+// .unlock.
+func (n *APIResourceNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	if name == ".unlock" {
+		return lock(ctx, n.stateStore, n.config, n.Path())
+	}
+	if name != "error" {
+		return syscall.EPERM
+	}
+	return n.recordedErrors().dismiss()
+}
+
+// cachedWatch returns the watch serving this directory, or nil if listings
+// should go to the API server.
+func (n *APIResourceNode) cachedWatch(ctx context.Context) *kube.Watch {
+	return n.subscribe(watchFor(ctx, n.config, n.contextName, n.groupVersion, n.namespace))
+}
+
+// subscribe subscribes the node to w, if it isn't already, so that the
+// kernel learns of objects appearing, changing and disappearing. It returns
+// w.
+func (n *APIResourceNode) subscribe(w *kube.Watch) *kube.Watch {
+	if w == nil {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.watch != w {
+		if n.unsubscribe != nil {
+			n.unsubscribe()
 		}
-		entries = append(entries, fuse.DirEntry{
-			Name: p,
-			Ino:  hash(fmt.Sprintf("%v/%v", n.Path(), p)),
-			Mode: fuse.S_IFDIR,
-		})
+		n.watch = w
+		n.unsubscribe = w.Subscribe(n.onWatchEvent)
 	}
-	return fs.NewListDirStream(entries), 0
+	return w
 }
 
-func getAPIResourceStruct(name, contextName, namespace string, groupVersion *GroupedAPIResource, stateStore *State) fs.InodeEmbedder {
+// notifyCheckInterval is how often a directory held for notifications checks
+// whether the kernel has forgotten it, and whether its watch stopped.
+const notifyCheckInterval = 30 * time.Second
+
+var _ = (fs.NodeOnAdder)((*APIResourceNode)(nil))
+
+// OnAdd starts holding the directory's watch when notifications are enabled.
+func (n *APIResourceNode) OnAdd(ctx context.Context) {
+	if n.config.Notifications() {
+		go n.holdWhileReferenced()
+	}
+}
+
+// holdWhileReferenced holds the directory's watch for as long as the kernel
+// references the directory, as it does while inotify watches it, so that
+// inotify users hear of changes without ever listing the directory. A watch
+// which stops, for instance as the context's credentials changed, is started
+// again.
+func (n *APIResourceNode) holdWhileReferenced() {
+	var held *kube.Watch
+	release := func() {}
+	ticker := time.NewTicker(notifyCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if held == nil || held.Stopped() {
+			release()
+			release = func() {}
+			held = n.subscribe(notifyWatchFor(context.Background(), n.config, n.contextName, n.groupVersion, n.namespace))
+			if held != nil {
+				release = held.Hold()
+			}
+		}
+		// The kernel only takes its reference once the lookup which made
+		// the node returns, so it is first checked a tick later.
+		<-ticker.C
+		if n.Forgotten() {
+			break
+		}
+	}
+
+	release()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.unsubscribe != nil {
+		n.unsubscribe()
+		n.unsubscribe = nil
+	}
+	n.watch = nil
+}
+
+// onWatchEvent keeps the kernel's view of the directory in step with the
+// cluster. Additions drop any cached negative entry, so that the next lookup
+// finds the object, and changes drop the cached content of the object's
+// files, such as def.yaml and edit.yaml. Of these notifications only
+// NotifyDelete makes the kernel emit an inotify event (IN_DELETE, and
+// IN_DELETE_SELF on the object's directory); the kernel has no IN_CREATE or
+// IN_MODIFY for changes made on the far side of FUSE.
+func (n *APIResourceNode) onWatchEvent(ev kube.WatchEvent) {
+	child := n.GetChild(ev.Name)
+	switch ev.Type {
+	case kube.WatchAdded:
+		n.NotifyEntry(ev.Name)
+	case kube.WatchDeleted:
+		if child == nil {
+			n.NotifyEntry(ev.Name)
+			return
+		}
+		n.NotifyDelete(ev.Name, child)
+	case kube.WatchModified:
+		if child != nil {
+			notifyContentChanged(child)
+		}
+	}
+}
+
+func getAPIResourceStruct(name, contextName, namespace string, groupVersion *GroupedAPIResource, stateStore *State, cfg *config.Config) fs.InodeEmbedder {
 	if groupVersion.GroupVersion() == "v1" && groupVersion.ResourceName == "pods" {
 		return &PodObjectsNode{
-			name: name,
-			contextName: contextName,
-			namespace: namespace,
-
-			stateStore: stateStore,
+			APIResourceActions: APIResourceActions{
+				name: name,
+				contextName: contextName,
+				namespace: namespace,
+				groupVersion: groupVersion,
+
+				stateStore: stateStore,
+				config:     cfg,
+			},
 		}
 	} else {
 		return &APIResourceActions{
@@ -317,6 +534,7 @@ func getAPIResourceStruct(name, contextName, namespace string, groupVersion *Gro
 			groupVersion: groupVersion,
 
 			stateStore: stateStore,
+			config:     cfg,
 		}
 	}
 
@@ -324,12 +542,32 @@ func getAPIResourceStruct(name, contextName, namespace string, groupVersion *Gro
 
 func (n *APIResourceNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	if name == "error" {
-		// TODO rc return a file whose string contents is the error
-		fmt.Printf("Error is %v", n.lastError)
-		return nil, syscall.ENOENT
+		return n.recordedErrors().lookup(ctx, &n.Inode, n.config)
 	}
 
-	node := getAPIResourceStruct(name, n.contextName, n.namespace, n.groupVersion, n.stateStore)
+	if name == "apply.yaml" {
+		path := fmt.Sprintf("%v/%v", n.Path(), name)
+		ch := mkApplyFile(ctx, &n.Inode, path, "", n.namespace, n.contextName, n.groupVersion, false, n.stateStore, n.config)
+		return ch, 0
+	}
+	if name == ".unlock" {
+		return mkUnlockFile(ctx, &n.Inode, n.Path(), n.stateStore, n.config), 0
+	}
+
+	if w := n.cachedWatch(ctx); w != nil {
+		if _, exists := w.Get(name); !exists {
+			return nil, syscall.ENOENT
+		}
+	} else if isManifestName(name) {
+		// Any name is taken to be an object, but a manifest being copied in
+		// must be found not to exist so that the kernel creates it.
+		_, err := kube.GetUnstructuredRaw(ctx, n.contextName, name, n.namespace, n.groupVersion.GVR())
+		if errors.Is(err, kube.ErrNotFound) {
+			return nil, syscall.ENOENT
+		}
+	}
+
+	node := getAPIResourceStruct(name, n.contextName, n.namespace, n.groupVersion, n.stateStore, n.config)
 
 	ch := n.NewInode(
 		ctx,
@@ -351,9 +589,8 @@ type APIResourceActions struct {
 	contextName  string
 	groupVersion *GroupedAPIResource
 
-	lastError  error
-	cli        *k8s.Clientset
 	stateStore *State
+	config     *config.Config
 }
 
 func (n *APIResourceActions) Path() string {
@@ -369,33 +606,70 @@ func (n *APIResourceActions) Path() string {
 }
 
 func (n *APIResourceActions) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	return fs.NewListDirStream(n.objectEntries()), 0
+}
+
+// objectEntries are the files every object's directory has, along with its
+// error and edit.conflict files when it has them. .unlock comes last, so that
+// `rm -r` reaches it after the object's views; see Unlink.
+func (n *APIResourceActions) objectEntries() []fuse.DirEntry {
 	entries := []fuse.DirEntry{
 		{
 			Name: "def.json",
-			Ino:  hash(fmt.Sprintf("%v/json", n.Path())),
-			Mode: fuse.S_IFDIR,
+			Ino:  hash(fmt.Sprintf("%v/def.json", n.Path())),
+			Mode: fuse.S_IFREG,
+		},
+		{
+			Name: "def.yaml",
+			Ino:  hash(fmt.Sprintf("%v/def.yaml", n.Path())),
+			Mode: fuse.S_IFREG,
 		},
 		{
 			Name: "edit.json",
-			Ino:  hash(fmt.Sprintf("%v/json", n.Path())),
-			Mode: fuse.S_IFDIR,
+			Ino:  hash(fmt.Sprintf("%v/edit.json", n.Path())),
+			Mode: fuse.S_IFREG,
+		},
+		{
+			Name: "edit.yaml",
+			Ino:  hash(fmt.Sprintf("%v/edit.yaml", n.Path())),
+			Mode: fuse.S_IFREG,
+		},
+		{
+			Name: "apply.yaml",
+			Ino:  hash(fmt.Sprintf("%v/apply.yaml", n.Path())),
+			Mode: fuse.S_IFREG,
+		},
+		{
+			Name: "dryrun.yaml",
+			Ino:  hash(fmt.Sprintf("%v/dryrun.yaml", n.Path())),
+			Mode: fuse.S_IFREG,
 		},
 	}
-	return fs.NewListDirStream(entries), 0
+	entries = n.recordedErrors().withEntry(entries)
+	if _, exists := getEditConflict(n.stateStore, n.conflictPath()); exists {
+		entries = append(entries, fuse.DirEntry{
+			Name: "edit.conflict",
+			Ino:  hash(n.conflictPath()),
+			Mode: fuse.S_IFREG,
+		})
+	}
+	return append(entries, unlockEntry(n.Path()))
 }
 
 func (n *APIResourceActions) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
-	if name == "def.json" {
+	if format, ok := definitionFiles[name]; ok {
 	ch := n.NewInode(
 		ctx,
-		&GenericJSONFile{
+		&GenericDefinitionFile{
 			name: n.name,
 			namespace: n.namespace,
 			contextName: n.contextName,
 			groupVersion: n.groupVersion,
+			format: format,
+			dir: n.Path(),
 
-			cli: n.cli,
 			stateStore: n.stateStore,
+			config:     n.config,
 		},
 		fs.StableAttr{
 			Mode: syscall.S_IFREG,
@@ -403,17 +677,19 @@ func (n *APIResourceActions) Lookup(ctx context.Context, name string, out *fuse.
 		},
 	)
 	return ch, 0
-	} else if name == "edit.json" {
+	} else if format, ok := editFiles[name]; ok {
 	ch := n.NewInode(
 		ctx,
-		&GenericEditableJSONFile{
+		&GenericEditableFile{
 			name: n.name,
 			namespace: n.namespace,
 			contextName: n.contextName,
 			groupVersion: n.groupVersion,
+			format: format,
+			objectPath: n.Path(),
 
-			cli: n.cli,
 			stateStore: n.stateStore,
+			config:     n.config,
 		},
 		fs.StableAttr{
 			Mode: syscall.S_IFREG,
@@ -421,11 +697,74 @@ func (n *APIResourceActions) Lookup(ctx context.Context, name string, out *fuse.
 		},
 	)
 	return ch, 0
+	} else if name == "edit.conflict" {
+		if _, exists := getEditConflict(n.stateStore, n.conflictPath()); !exists {
+			return nil, syscall.ENOENT
+		}
+		ch := n.NewInode(
+			ctx,
+			&EditConflictFile{
+				path: n.conflictPath(),
+
+				stateStore: n.stateStore,
+				config:     n.config,
+			},
+			fs.StableAttr{
+				Mode: syscall.S_IFREG,
+				Ino:  hash(n.conflictPath()),
+			},
+		)
+		return ch, 0
+	} else if name == "apply.yaml" || name == "dryrun.yaml" {
+		path := fmt.Sprintf("%v/%v", n.Path(), name)
+		dryRun := name == "dryrun.yaml"
+		ch := mkApplyFile(ctx, &n.Inode, path, n.name, n.namespace, n.contextName, n.groupVersion, dryRun, n.stateStore, n.config)
+		return ch, 0
+	} else if name == ".unlock" {
+		return mkUnlockFile(ctx, &n.Inode, n.Path(), n.stateStore, n.config), 0
+	} else if name == "error" {
+		return n.recordedErrors().lookup(ctx, &n.Inode, n.config)
 	}
 	return nil, syscall.ENOENT
 }
 
 
+// recordedErrors are the failures to read or edit the object.
+func (n *APIResourceActions) recordedErrors() dirErrors {
+	return dirErrors{n.stateStore, n.Path()}
+}
+
+func (n *APIResourceActions) conflictPath() string {
+	return fmt.Sprintf("%v/edit.conflict", n.Path())
+}
+
+var _ = (fs.NodeUnlinker)((*APIResourceActions)(nil))
+
+// Unlink dismisses the edit.conflict file, or locks the object by removing
+// its .unlock. The object's other files are views of it, whose removal is
+// only acknowledged; see removeObjectView. While `rm -r` is removing them,
+// .unlock is left in place until the object's directory is removed, so that
+// the object's unlock can authorise its deletion.
+func (n *APIResourceActions) Unlink(ctx context.Context, name string) syscall.Errno {
+	if name == ".unlock" {
+		if n.removing() {
+			return 0
+		}
+		return lock(ctx, n.stateStore, n.config, n.Path())
+	}
+	if name == "error" {
+		return n.recordedErrors().dismiss()
+	}
+	if name != "edit.conflict" {
+		return n.removeObjectView()
+	}
+	if _, exists := getEditConflict(n.stateStore, n.conflictPath()); !exists {
+		return syscall.ENOENT
+	}
+	n.stateStore.Delete(n.conflictPath())
+	return 0
+}
+
 func splitGroupVersion(groupVersion string) (string, string, error) {
 	if groupVersion == "v1" {
 		// The core api is a special case
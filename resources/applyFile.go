@@ -0,0 +1,326 @@
+package resources
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+)
+
+// ========== Apply file ==========
+
+// ApplyFile is the apply.yaml of an object, or of a resource's namespace
+// directory when name is empty. Manifests written to it are server-side
+// applied when the file is closed, and reading it shows the outcome of the
+// last apply.
+//
+// With dryRun set it is instead an object's dryrun.yaml. Manifests are sent
+// with DryRun=All, as an update if they carry a resourceVersion and as an
+// apply otherwise, and reading it shows what the API server would have
+// persisted along with a diff against the live object.
+type ApplyFile struct {
+	fs.Inode
+
+	name         string
+	namespace    string
+	contextName  string
+	groupVersion *GroupedAPIResource
+	dryRun       bool
+	// path is the path of the file, under which the outcome of the last
+	// apply is cached.
+	path string
+
+	stateStore *State
+	config     *config.Config
+}
+
+// dryRunResultTTL is how long the outcome of a dry run is kept. It compares
+// against the live object as it was, so it soon goes stale.
+const dryRunResultTTL = 5 * time.Minute
+
+// applyResult is the outcome of the last apply through an apply file.
+type applyResult []byte
+
+func (r applyResult) Size() int64 {
+	return int64(len(r))
+}
+
+// mkApplyFile returns the apply file at path. The outcome of the last apply
+// is kept in the state store rather than in the node, so that it outlives
+// the kernel's inode for as long as it is cached.
+func mkApplyFile(ctx context.Context, parent *fs.Inode, path, name, namespace, contextName string, groupVersion *GroupedAPIResource, dryRun bool, stateStore *State, cfg *config.Config) *fs.Inode {
+	return parent.NewInode(
+		ctx,
+		&ApplyFile{
+			name:         name,
+			namespace:    namespace,
+			contextName:  contextName,
+			groupVersion: groupVersion,
+			dryRun:       dryRun,
+			path:         path,
+
+			stateStore: stateStore,
+			config:     cfg,
+		},
+		fs.StableAttr{
+			Mode: syscall.S_IFREG,
+			Ino:  hash(path),
+		},
+	)
+}
+
+var _ = (fs.NodeOpener)((*ApplyFile)(nil))
+
+func (f *ApplyFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	if f.config.ReadOnly && openFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	if errno := checkSecrets(f.stateStore, f.config, f.contextName, f.groupVersion); errno != 0 {
+		return nil, 0, errno
+	}
+	return &applyFileHandle{file: f}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+var _ = (fs.NodeSetattrer)((*ApplyFile)(nil))
+
+// Setattr accepts truncation of the file, so that `>` redirection works. The
+// manifest itself is held by the open handle.
+func (f *ApplyFile) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if h, ok := fh.(*applyFileHandle); ok {
+		return h.Setattr(ctx, in, out)
+	}
+	return 0
+}
+
+// result returns the outcome of the last apply, if it is still cached.
+func (f *ApplyFile) result() []byte {
+	elem, exists := f.stateStore.Get(f.path)
+	if !exists {
+		return nil
+	}
+	result, ok := elem.(applyResult)
+	if !ok {
+		panic("failed type assertion")
+	}
+	return result
+}
+
+func (f *ApplyFile) action() string {
+	if f.dryRun {
+		return "dry run"
+	}
+	return "apply"
+}
+
+func (f *ApplyFile) setResult(result []byte, err error) {
+	if err != nil {
+		log.Printf("%v to %v failed | %v", f.action(), f.groupVersion.ResourceName, err)
+	}
+	if len(result) == 0 {
+		f.stateStore.Delete(f.path)
+		return
+	}
+	if f.dryRun {
+		f.stateStore.PutTTL(f.path, applyResult(result), dryRunResultTTL)
+		return
+	}
+	f.stateStore.Put(f.path, applyResult(result))
+}
+
+// apply submits every object in manifest, stopping at the first failure.
+func (f *ApplyFile) apply(ctx context.Context, manifest []byte) syscall.Errno {
+	// Dry runs change nothing, so only need reading to be allowed.
+	if !f.dryRun {
+		if errno := checkPolicy(f.stateStore, f.config, f.contextName, config.OpEdit); errno != 0 {
+			err := fmt.Errorf("apply was refused by the policy for context %v", f.contextName)
+			f.setResult([]byte(fmt.Sprintf("%v\n", err)), err)
+			return errno
+		}
+	}
+	objs, err := f.parseManifest(manifest)
+	if err != nil {
+		f.setResult([]byte(fmt.Sprintf("%v\n", err)), err)
+		return syscall.EINVAL
+	}
+
+	var out bytes.Buffer
+	for i, obj := range objs {
+		if !f.dryRun {
+			if errno, err := checkUnlocked(ctx, f.stateStore, f.config, "apply", f.contextName, manifestObjectPath(f.contextName, f.groupVersion, obj)); errno != 0 {
+				out.WriteString(fmt.Sprintf("%v\n", err))
+				f.setResult(out.Bytes(), err)
+				return errno
+			}
+		}
+		applied, err := f.submit(ctx, obj)
+		if !f.dryRun {
+			audit(ctx, f.config, "apply", manifestObjectPath(f.contextName, f.groupVersion, obj), err)
+		}
+		if err != nil {
+			msg := applyFailure(f.action(), obj.GetName(), err)
+			out.WriteString(msg)
+			f.setResult(out.Bytes(), err)
+			return errnoFor(err)
+		}
+
+		if i > 0 {
+			out.WriteString("---\n")
+		}
+		if f.dryRun {
+			f.renderDryRun(ctx, &out, applied)
+			continue
+		}
+		rendered, err := formatYAML.render(applied)
+		if err != nil {
+			f.setResult([]byte(fmt.Sprintf("%v\n", err)), err)
+			return syscall.EIO
+		}
+		out.Write(rendered)
+	}
+	f.setResult(out.Bytes(), nil)
+	return 0
+}
+
+func (f *ApplyFile) submit(ctx context.Context, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if f.dryRun && obj.GetResourceVersion() != "" {
+		// A manifest with a resourceVersion is a whole object, as edited
+		// through edit.yaml, so preview it as the update it would be.
+		return kube.WriteUnstructured(
+			ctx, f.contextName, obj.GetName(), obj.GetNamespace(),
+			f.groupVersion.GVR(), obj, true,
+		)
+	}
+	return kube.ApplyUnstructured(
+		ctx, f.contextName, obj.GetNamespace(),
+		f.groupVersion.GVR(), obj,
+		f.config.FieldManager, f.config.ApplyForce, f.dryRun,
+	)
+}
+
+// renderDryRun writes the object the API server would persist, and how it
+// differs from the live object.
+func (f *ApplyFile) renderDryRun(ctx context.Context, out *bytes.Buffer, result *unstructured.Unstructured) {
+	out.WriteString("# The API server would persist:\n")
+	rendered := renderForDiff(formatYAML, result)
+	out.WriteString(rendered)
+
+	live, err := kube.GetUnstructuredRaw(ctx, f.contextName, result.GetName(), result.GetNamespace(), f.groupVersion.GVR())
+	switch {
+	case errors.Is(err, kube.ErrNotFound):
+		fmt.Fprintf(out, "# %v doesn't exist yet, and would be created.\n", result.GetName())
+	case err != nil:
+		fmt.Fprintf(out, "# Failed to fetch the live object to compare with | %v\n", err)
+	default:
+		diff := unifiedDiff("live", "dry run", renderForDiff(formatYAML, live), rendered)
+		if diff == "" {
+			out.WriteString("# This is the same as the live object.\n")
+			return
+		}
+		out.WriteString("# Changes from the live object:\n")
+		out.WriteString(diff)
+	}
+}
+
+// applyFailure renders a failed apply for the user, listing the owners of any
+// conflicting fields.
+func applyFailure(action, name string, err error) string {
+	conflicts := kube.ApplyConflicts(err)
+	if conflicts == "" {
+		return fmt.Sprintf("%v of %v failed | %v\n", action, name, err)
+	}
+	return fmt.Sprintf(
+		"%v of %v conflicts with fields owned by other managers:\n%v"+
+			"Apply again with --apply-force (applyForce: true) to take ownership of them.\n",
+		action, name, conflicts,
+	)
+}
+
+// parseManifest splits manifest into its objects, checking each belongs in
+// this directory. Missing names and namespaces are filled in from the path.
+func (f *ApplyFile) parseManifest(manifest []byte) ([]*unstructured.Unstructured, error) {
+	objs, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		err = checkManifestObject(f.groupVersion, f.namespace, f.name, obj)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
+// ========== Apply file handle ==========
+
+// applyFileHandle buffers a manifest written to an apply file. Until
+// something is written, reads show the outcome of the last apply.
+type applyFileHandle struct {
+	fileBuffer
+	file *ApplyFile
+}
+
+var _ = (fs.FileReader)((*applyFileHandle)(nil))
+
+func (fh *applyFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if fh.dirty {
+		return fh.read(dest, off), 0
+	}
+	result := fileBuffer{buf: fh.file.result()}
+	return result.read(dest, off), 0
+}
+
+var _ = (fs.FileWriter)((*applyFileHandle)(nil))
+
+func (fh *applyFileHandle) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	if fh.file.config.ReadOnly {
+		return 0, syscall.EROFS
+	}
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.write(data, off)
+	return uint32(len(data)), 0
+}
+
+var _ = (fs.FileSetattrer)((*applyFileHandle)(nil))
+
+func (fh *applyFileHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if sz, ok := in.GetSize(); ok {
+		fh.resize(int64(sz))
+		fh.dirty = true
+	}
+	out.Size = uint64(len(fh.buf))
+	return 0
+}
+
+var _ = (fs.FileFlusher)((*applyFileHandle)(nil))
+
+// Flush applies the manifest written through this handle, if any, so that a
+// failed apply is reported by close(2).
+func (fh *applyFileHandle) Flush(ctx context.Context) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if !fh.dirty || len(fh.buf) == 0 {
+		return 0
+	}
+	errno := fh.file.apply(ctx, fh.buf)
+	fh.buf = nil
+	fh.dirty = false
+	return errno
+}
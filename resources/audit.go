@@ -0,0 +1,91 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"rorycrispin.co.uk/kubefs/config"
+)
+
+// auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Path   string    `json:"path"`
+	// UID and PID are of the process which made the change, when known.
+	UID    uint32 `json:"uid,omitempty"`
+	PID    uint32 `json:"pid,omitempty"`
+	Result string `json:"result"`
+}
+
+// auditLog appends entries to the file at cfg.AuditLog, which is opened on
+// first use.
+var auditLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// audit records that action was taken on path, and whether it succeeded.
+// Entries go to the audit log if one is configured, and to kubefs's own log
+// otherwise. If the audit log can't be written, the entry goes to kubefs's
+// log instead, so that it isn't lost.
+func audit(ctx context.Context, cfg *config.Config, action, path string, err error) {
+	entry := auditEntry{
+		Time:   time.Now().UTC(),
+		Action: action,
+		Path:   path,
+		Result: "ok",
+	}
+	if caller, ok := fuse.FromContext(ctx); ok {
+		entry.UID = caller.Uid
+		entry.PID = caller.Pid
+	}
+	if err != nil {
+		entry.Result = err.Error()
+	}
+
+	line, jsonErr := json.Marshal(entry)
+	if jsonErr != nil {
+		log.Printf("Failed to encode audit entry | %v", jsonErr)
+		return
+	}
+	if cfg.AuditLog == "" {
+		log.Printf("AUDIT %s", line)
+		return
+	}
+
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+	if auditLog.file == nil || auditLog.path != cfg.AuditLog {
+		f, openErr := openAuditLog(cfg.AuditLog)
+		if openErr != nil {
+			log.Printf("Failed to open audit log | %v", openErr)
+			log.Printf("AUDIT %s", line)
+			return
+		}
+		if auditLog.file != nil {
+			auditLog.file.Close()
+		}
+		auditLog.file, auditLog.path = f, cfg.AuditLog
+	}
+	if _, writeErr := auditLog.file.Write(append(line, '\n')); writeErr != nil {
+		log.Printf("Failed to write audit log | %v", writeErr)
+		log.Printf("AUDIT %s", line)
+	}
+}
+
+func openAuditLog(path string) (*os.File, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+}
@@ -4,15 +4,12 @@ import (
 	"context"
 	"syscall"
 	"fmt"
-	"encoding/json"
-	"bytes"
+	"sync"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
-	k8s "k8s.io/client-go/kubernetes"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
-	kube "rorycrispin.co.uk/kubefs/kubernetes"
+	"rorycrispin.co.uk/kubefs/config"
 )
 
 // roBytesFileHandle is a file handle that carries separate content for
@@ -52,7 +49,6 @@ func (fh *rwBytesFileHandle) Read(ctx context.Context, dest []byte, off int64) (
 }
 
 func (fh *rwBytesFileHandle) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
-	fmt.Printf("rwBytesFileWrite == %v\n", string(data))
 	writtenSize := uint32(len(data))
 
 	return writtenSize, 0
@@ -68,116 +64,86 @@ func (fh *rwBytesFileHandle) Fsync(ctx context.Context, flags uint32) syscall.Er
 	return 0
 }
 
-// ========== Error file ==========
-
-type ErrorFile struct {
-	fs.Inode
-
-	err error
-
-	stateStore *State
+// fileBuffer is the content of a writable file handle. Editors write files
+// in pieces, at offsets, and truncate them first, so the content is only
+// looked at once the handle is flushed.
+type fileBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	// dirty is set while buf holds writes which have not been acted on.
+	dirty bool
 }
 
-func (f *ErrorFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
-	if fuseFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
-		// disallow writes
-		return nil, 0, syscall.EROFS
+// read returns the content at off. Callers must hold b.mu.
+func (b *fileBuffer) read(dest []byte, off int64) fuse.ReadResult {
+	if off >= int64(len(b.buf)) {
+		return fuse.ReadResultData(nil)
 	}
-
-	fh = &roBytesFileHandle{
-		content: []byte(fmt.Sprintf("%v", f.err)),
+	end := off + int64(len(dest))
+	if end > int64(len(b.buf)) {
+		end = int64(len(b.buf))
 	}
-	return fh, fuse.FOPEN_DIRECT_IO, 0
+	return fuse.ReadResultData(append([]byte(nil), b.buf[off:end]...))
 }
 
-// ============= Editable JSON File ==========
-//
-
-
-type editableJSONFileHandle struct {
-	content *unstructured.Unstructured
-
-	buf bytes.Buffer
-
-	name         string
-	namespace    string
-	contextName  string
-	groupVersion *GroupedAPIResource
-
-	lastError  error
-	cli        *k8s.Clientset
-	stateStore *State
-
+// write stores data at off, growing the buffer as needed. Callers must hold
+// b.mu.
+func (b *fileBuffer) write(data []byte, off int64) {
+	end := off + int64(len(data))
+	if end > int64(len(b.buf)) {
+		b.resize(end)
+	}
+	copy(b.buf[off:], data)
+	b.dirty = true
 }
 
-type safeContent struct {
-	UnlockEdit bool `json:"unlockForEdit"`
-	Content *unstructured.Unstructured `json:"content"`
+// resize grows or truncates the buffer to size. Callers must hold b.mu.
+func (b *fileBuffer) resize(size int64) {
+	if size <= int64(len(b.buf)) {
+		b.buf = b.buf[:size]
+		return
+	}
+	grown := make([]byte, size)
+	copy(grown, b.buf)
+	b.buf = grown
 }
 
-func (fh *editableJSONFileHandle) GetSafeContent() ([]byte, error) {
-	rv := safeContent{
-		Content: fh.content,
-	}
-	return json.MarshalIndent(rv, "", "    ")
+// ========== Error file ==========
 
-}
+// ErrorFile is a directory's error file, explaining why something done in the
+// directory failed.
+type ErrorFile struct {
+	fs.Inode
 
-func (fh *editableJSONFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
-	fmt.Printf(">> Read editableJSONFileHandle")
-	content, err := fh.GetSafeContent()
-	if err != nil {
-		// TODO rc
-		panic(err)
-	}
-	end := off + int64(len(dest))
-	if end > int64(len(content)) {
-		end = int64(len(content))
-	}
+	err error
+	// recorded, if set, is read from each time the file is opened, so that
+	// the file shows the latest error rather than the one it was made with.
+	recorded dirErrors
 
-	// We could copy to the `dest` buffer, but since we have a
-	// []byte already, return that.
-	return fuse.ReadResultData(content[off:end]), 0
+	stateStore *State
+	config     *config.Config
 }
 
-func (fh *editableJSONFileHandle) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
-	fmt.Printf("rwBytesFileWrite == %v\n", string(data))
-	if off == 0 {
-		fh.buf = *bytes.NewBuffer(data)
-	} else {
-		fh.buf.Write(data)
+func (f *ErrorFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	if openFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
+		// disallow writes
+		return nil, 0, syscall.EROFS
 	}
 
-	complete := &safeContent{}
-	err := json.Unmarshal(fh.buf.Bytes(), complete)
-	if err != nil {
-		fmt.Printf("JSON Marshal Error - assume we haven't finished writing the file yet... %v\n", err)
-	} else {
-		if !complete.UnlockEdit {
-			return 0, syscall.EROFS
-		}
-		_, err := kube.WriteUnstructured(
-			ctx, fh.contextName, fh.name, fh.namespace,
-			fh.groupVersion.GVR(),
-			complete.Content,
-		)
-		if err != nil {
-			fmt.Printf("Error while writing: %v\n", err)
-			return 0, syscall.ESTALE
+	var content []byte
+	if f.recorded.stateStore != nil {
+		rec, exists := f.recorded.last()
+		if !exists {
+			return nil, 0, syscall.ENOENT
 		}
+		content = rec.render()
+	} else if f.err != nil {
+		content = []byte(fmt.Sprintf("%v\n", f.err))
+	} else {
+		return nil, 0, syscall.ENOENT
 	}
-
-	writtenSize := uint32(len(data))
-	fmt.Printf("WROTE at offset %v = writtenSize was %v\n", off, writtenSize)
-	return writtenSize, 0
-}
-
-func (fh *editableJSONFileHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
-	fmt.Printf("rwBytesFileSetattr \n")
-	return 0
-}
-
-func (fh *editableJSONFileHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
-	fmt.Printf("rwBytesFileFsync, flags: %b\n", flags)
-	return 0
+	fh = &roBytesFileHandle{
+		content: content,
+	}
+	return fh, fuse.FOPEN_DIRECT_IO, 0
 }
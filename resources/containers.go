@@ -4,15 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"strings"
-	"sync"
 	"syscall"
-	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
 
-	k8s "k8s.io/client-go/kubernetes"
+	"rorycrispin.co.uk/kubefs/config"
 	kube "rorycrispin.co.uk/kubefs/kubernetes"
 )
 
@@ -24,8 +23,8 @@ type RootContainerNode struct {
 	namespace string
 	contextName string
 
-	cli *k8s.Clientset
 	stateStore *State
+	config     *config.Config
 }
 
 func (n *RootContainerNode) Path() string {
@@ -34,30 +33,26 @@ func (n *RootContainerNode) Path() string {
 	)
 }
 
-
-func (n *RootContainerNode) ensureCLI() error {
-	if n.cli != nil {
-		return nil
-	}
-	cli, err := kube.GetK8sClient(n.contextName)
-	if err != nil {
-		return err
-	}
-	n.cli = cli
-	return nil
+// recordedErrors are the failures to list the pod's containers.
+func (n *RootContainerNode) recordedErrors() dirErrors {
+	return dirErrors{n.stateStore, fmt.Sprintf("%v/containers", n.Path())}
 }
 
-func (n *RootContainerNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	fmt.Printf("READDIR RootContainerNode: %#v\n", ctx)
 
-	n.ensureCLI()
+func (n *RootContainerNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	cli, err := kube.ClientsFor(n.contextName).Typed()
+	if err != nil {
+		n.recordedErrors().record(fmt.Errorf("failed to get client while listing containers | %w", err))
+		return readDirErrResponse(n.recordedErrors().dir)
+	}
 
-	results, err := kube.GetContainers(ctx, n.cli, n.pod, n.namespace)
+	results, err := kube.GetContainers(ctx, cli, n.pod, n.namespace)
 	if err != nil {
-		panic(err)
+		n.recordedErrors().record(fmt.Errorf("failed to list containers | %w", err))
+		return readDirErrResponse(n.recordedErrors().dir)
 	}
 
-	entries := make([]fuse.DirEntry, 0, len(results))
+	entries := make([]fuse.DirEntry, 0, len(results)+1)
 	for _, p := range results {
 		if p == "" {
 			continue
@@ -68,11 +63,14 @@ func (n *RootContainerNode) Readdir(ctx context.Context) (fs.DirStream, syscall.
 			Mode: fuse.S_IFREG,
 		})
 	}
-	return fs.NewListDirStream(entries), 0
+	return fs.NewListDirStream(n.recordedErrors().withEntry(entries)), 0
 }
 
 func (n *RootContainerNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	fmt.Printf("LOOKUP OF %s' \n", name)
+	if name == "error" {
+		return n.recordedErrors().lookup(ctx, &n.Inode, n.config)
+	}
 
 	ch := n.NewInode(
 		ctx,
@@ -82,8 +80,8 @@ func (n *RootContainerNode) Lookup(ctx context.Context, name string, out *fuse.E
 			name:      name,
 			contextName: n.contextName,
 
-			cli:       n.cli,
 			stateStore: n.stateStore,
+			config:     n.config,
 		},
 		fs.StableAttr{
 			Mode: syscall.S_IFDIR,
@@ -104,8 +102,8 @@ type RootContainerObjectsNode struct {
 	name      string
 	contextName string
 
-	cli *k8s.Clientset
 	stateStore *State
+	config     *config.Config
 }
 
 // Ensure we are implementing the NodeReaddirer interface
@@ -115,62 +113,78 @@ func (n *RootContainerObjectsNode) Path() string {
 	)
 }
 
+// logs returns the container's logs as selected by q.
+func (n *RootContainerObjectsNode) logs(q logQuery) containerLogs {
+	return containerLogs{
+		pod:         n.pod,
+		namespace:   n.namespace,
+		container:   n.name,
+		contextName: n.contextName,
+		query:       q,
+		dir:         n.Path(),
+	}
+}
+
+// recordedErrors are the failures to fetch the container's logs and to exec
+// in it.
+func (n *RootContainerObjectsNode) recordedErrors() dirErrors {
+	return dirErrors{n.stateStore, n.Path()}
+}
+
 
 var _ = (fs.NodeReaddirer)((*RootContainerObjectsNode)(nil))
 func (n *RootContainerObjectsNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	fmt.Printf("READDIR RootContainerObjectsNode: ns: %s %#v\n", n.namespace, ctx)
-
 	entries := []fuse.DirEntry{
 		{
 			Name: "logs",
 			Ino: hash(fmt.Sprintf("%v/logs", n.Path())),
 			Mode: fuse.S_IFREG,
 		},
+		{
+			Name: "logs.d",
+			Ino: hash(fmt.Sprintf("%v/logs.d", n.Path())),
+			Mode: fuse.S_IFDIR,
+		},
 		{
 			Name: "logs-previous",
 			Ino: hash(fmt.Sprintf("%v/logs-previous", n.Path())),
-			Mode: fuse.S_IFDIR,
+			Mode: fuse.S_IFREG,
+		},
+		{
+			Name: "logs-follow",
+			Ino: hash(fmt.Sprintf("%v/logs-follow", n.Path())),
+			Mode: fuse.S_IFREG,
 		},
 		{
 			Name: "exec",
 			Ino: hash(fmt.Sprintf("%v/exec", n.Path())),
 			Mode: fuse.S_IFDIR,
 		},
+		{
+			Name: "status",
+			Ino: hash(fmt.Sprintf("%v/status", n.Path())),
+			Mode: fuse.S_IFREG,
+		},
 	}
-	return fs.NewListDirStream(entries), 0
+	return fs.NewListDirStream(n.recordedErrors().withEntry(entries)), 0
 }
 
 func (n *RootContainerObjectsNode) mkContainerExecFile(ctx context.Context) *fs.Inode {
-	stateKey := fmt.Sprintf("%v/exec", n.Path())
-
-	var node *ContainerExecFile
-
-	elem, exist := n.stateStore.Get(stateKey)
-	if exist {
-		var ok bool
-		node, ok = elem.(*ContainerExecFile)
-		if !ok {
-			panic("failed type assertion")
-		}
-	} else  {
-		fmt.Printf("Creating new container exec file\n")
-		node = &ContainerExecFile{
-				name: n.name,
-				pod:      n.pod,
-				namespace: n.namespace,
-				contextName: n.contextName,
-
-				cli: n.cli,
-				stateStore: n.stateStore,
-			}
-		n.stateStore.Put(stateKey, node)
-	}
-
 	return n.NewInode(
-		ctx, node,
+		ctx,
+		&ContainerExecFile{
+			name:        n.name,
+			pod:         n.pod,
+			namespace:   n.namespace,
+			contextName: n.contextName,
+			dir:         n.Path(),
+
+			stateStore: n.stateStore,
+			config:     n.config,
+		},
 		fs.StableAttr{
 			Mode: syscall.S_IFREG,
-			Ino: hash(stateKey),
+			Ino: hash(fmt.Sprintf("%v/exec", n.Path())),
 		},
 	)
 
@@ -178,31 +192,82 @@ func (n *RootContainerObjectsNode) mkContainerExecFile(ctx context.Context) *fs.
 
 func (n *RootContainerObjectsNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	fmt.Printf("LOOKUP OF %s on RootContainerObjectsNode: %s' \n", name, n.namespace)
-	var previous bool
+	if name == "error" {
+		return n.recordedErrors().lookup(ctx, &n.Inode, n.config)
+	}
+	if name == "status" {
+		ch := n.NewInode(
+			ctx,
+			&ContainerStatusFile{
+				name:        n.name,
+				pod:         n.pod,
+				namespace:   n.namespace,
+				contextName: n.contextName,
+				dir:         n.Path(),
+
+				stateStore: n.stateStore,
+				config:     n.config,
+			},
+			fs.StableAttr{
+				Mode: syscall.S_IFREG,
+				Ino: hash(fmt.Sprintf("%v/status", n.Path())),
+			},
+		)
+		return ch, 0
+	}
 	if name == "exec" {
 		ch := n.mkContainerExecFile(ctx)
-		fmt.Printf(">> Assign inode %v to exec file for container %v of pod %v\n", ch.String(), n.name, n.pod)
 		return ch, 0
 	}
-	if name == "logs" {
-		previous = false
-	} else if name == "logs-previous" {
-		previous = true
-	} else {
+	if name == "logs-follow" {
+		tailLines := followTailLines
+		ch := n.NewInode(
+			ctx,
+			&ContainerFollowFile{
+				logs: n.logs(logQuery{
+					follow:    true,
+					tailLines: &tailLines,
+				}),
+
+				stateStore: n.stateStore,
+				config:     n.config,
+			},
+			fs.StableAttr{
+				Mode: syscall.S_IFREG,
+				Ino: hash(fmt.Sprintf("%v/%v", n.Path(), name)),
+			},
+		)
+		return ch, 0
+	}
+	if name == "logs.d" {
+		logs := n.logs(logQuery{})
+		logs.dir = fmt.Sprintf("%v/logs.d", n.Path())
+		ch := n.NewInode(
+			ctx,
+			&ContainerLogsNode{
+				logs: logs,
+
+				stateStore: n.stateStore,
+				config:     n.config,
+			},
+			fs.StableAttr{
+				Mode: syscall.S_IFDIR,
+				Ino: hash(logs.dir),
+			},
+		)
+		return ch, 0
+	}
+	if name != "logs" && name != "logs-previous" {
 		fmt.Printf("RootContainerObjects lookup of unrecognised object type %v, %s\n", name, name)
 		return nil, syscall.ENOENT
 	}
 	ch := n.NewInode(
 		ctx,
 		&ContainerLogsFile{
-			name: n.name,
-			pod:      n.pod,
-			namespace: n.namespace,
-			previous: previous,
-			contextName: n.contextName,
+			logs: n.logs(logQuery{previous: name == "logs-previous"}),
 
-			cli: n.cli,
 			stateStore: n.stateStore,
+			config:     n.config,
 		},
 		fs.StableAttr{
 			Mode: syscall.S_IFREG,
@@ -212,87 +277,115 @@ func (n *RootContainerObjectsNode) Lookup(ctx context.Context, name string, out
 	return ch, 0
 }
 
-// ========== Container Logs file ==========
+// ========== Container status file ==========
 
-type ContainerLogsFile struct {
+// ContainerStatusFile describes the state of the container and its pod, and
+// the pod's recent events.
+type ContainerStatusFile struct {
 	fs.Inode
-	name      string
-	pod       string
-	namespace string
-	previous bool
+	name        string
+	pod         string
+	namespace   string
 	contextName string
+	// dir is the path of the container's directory, whose error file
+	// explains failures to fetch the status.
+	dir string
 
-	cli *k8s.Clientset
 	stateStore *State
+	config     *config.Config
 }
 
-func (n *ContainerLogsFile) ensureCLI() error {
-	if n.cli != nil {
-		return nil
-	}
-	cli, err := kube.GetK8sClient(n.contextName)
-	if err != nil {
-		return err
-	}
-	n.cli = cli
-	return nil
-}
-
-var _ = (fs.NodeOpener)((*ContainerLogsFile)(nil))
+var _ = (fs.NodeOpener)((*ContainerStatusFile)(nil))
 
-func (f *ContainerLogsFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
-	fmt.Printf("Open logs\n")
+func (f *ContainerStatusFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
 	// disallow writes
-	if fuseFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
+	if openFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
 		return nil, 0, syscall.EROFS
 	}
 
-	f.ensureCLI()
-
-	var logs []byte
-	var err error
-	if f.previous {
-		logs, err = kube.GetPreviousLogs(ctx, f.cli, f.pod, f.name, f.namespace)
-	} else {
-		logs, err = kube.GetLogs(ctx, f.cli, f.pod, f.name, f.namespace)
-	}
-
-	if errors.Is(err, kube.ErrNotFound) {
-		fmt.Printf("Err not found while opening logs\n")
-		return nil, 0, syscall.ENOENT
+	var status []byte
+	cli, err := kube.ClientsFor(f.contextName).Typed()
+	if err == nil {
+		status, err = kube.DiagnoseContainer(ctx, cli, f.pod, f.name, f.namespace)
 	}
 	if err != nil {
-		fh = &roBytesFileHandle{
-			content: []byte(fmt.Sprintf("%v", err)),
+		if !errors.Is(err, kube.ErrNotFound) {
+			recordError(f.stateStore, f.dir, fmt.Errorf("failed to fetch status | %w", err))
 		}
-		return fh, fuse.FOPEN_DIRECT_IO, 0
+		return nil, 0, errnoFor(err)
 	}
 
 	fh = &roBytesFileHandle{
-		content: logs,
+		content: status,
 	}
-
 	// Return FOPEN_DIRECT_IO so content is not cached.
 	return fh, fuse.FOPEN_DIRECT_IO, 0
 }
 
+// diagnose adds the state of the pod and container to err, which explains
+// most failures to exec in a container or fetch its logs, such as a
+// container whose image can't be pulled. If the state can't be fetched
+// either, err is returned as it was.
+func diagnose(ctx context.Context, contextName, pod, container, namespace string, err error) error {
+	cli, cliErr := kube.ClientsFor(contextName).Typed()
+	if cliErr != nil {
+		return err
+	}
+	diagnosis, diagErr := kube.DiagnoseContainer(ctx, cli, pod, container, namespace)
+	if diagErr != nil {
+		log.Printf("Failed to diagnose container %v of pod %v | %v", container, pod, diagErr)
+		return err
+	}
+	return fmt.Errorf("%w\n\n%s", err, diagnosis)
+}
+
 // ========== Container Exec file ==========
 
+// ContainerExecFile runs the commands written to it in the container, and
+// reading it shows the output of the last one. The output is kept in the
+// state store rather than in the node, so that it outlives the kernel's inode
+// for as long as it is cached.
 type ContainerExecFile struct {
 	fs.Inode
 	name      string
 	pod       string
 	namespace string
 	contextName string
+	// dir is the path of the container's directory, whose error file
+	// explains failed execs.
+	dir string
 
-	// When file systems are mutable, all access must use
-	// synchronization.
-	mu      sync.Mutex
-	content []byte
-	mtime   time.Time
-
-	cli *k8s.Clientset
 	stateStore *State
+	config     *config.Config
+}
+
+// execOutput is the output of the last command run through an exec file.
+type execOutput []byte
+
+func (o execOutput) Size() int64 {
+	return int64(len(o))
+}
+
+// outputPath is where the output of the last command is cached.
+func (bn *ContainerExecFile) outputPath() string {
+	return fmt.Sprintf("%v/exec", bn.dir)
+}
+
+// output returns the output of the last command, if it is still cached.
+func (bn *ContainerExecFile) output() []byte {
+	elem, exists := bn.stateStore.Get(bn.outputPath())
+	if !exists {
+		return nil
+	}
+	output, ok := elem.(execOutput)
+	if !ok {
+		panic("failed type assertion")
+	}
+	return output
+}
+
+func (bn *ContainerExecFile) setOutput(output []byte) {
+	bn.stateStore.Put(bn.outputPath(), execOutput(output))
 }
 
 var _ = (fs.NodeAccesser)((*ContainerExecFile)(nil))
@@ -316,10 +409,14 @@ func (f *ContainerExecFile) Open(ctx context.Context, openFlags uint32) (fh fs.F
 var _ = (fs.NodeWriter)((*ContainerExecFile)(nil))
 
 func (bn *ContainerExecFile) Write(ctx context.Context, fh fs.FileHandle, buf []byte, off int64) (uint32, syscall.Errno) {
-	if off != 0 {
-		fmt.Printf("Write with offset neq 0 (was %v)\n", off)
+	if bn.config.ReadOnly {
+		return 0, syscall.EROFS
 	}
-
+	if errno := checkPolicy(bn.stateStore, bn.config, bn.contextName, config.OpExec); errno != 0 {
+		return 0, errno
+	}
+	// Each write is a whole command, and replaces the output of the last, so
+	// where it was written is of no consequence.
 	cmd := strings.Split(strings.TrimSpace(string(buf)), " ")
 	stdOut, stdErr, err := kube.ExecCommand(
 		ctx,
@@ -327,42 +424,45 @@ func (bn *ContainerExecFile) Write(ctx context.Context, fh fs.FileHandle, buf []
 		bn.pod, bn.name, bn.namespace,
 		cmd,
 	)
-	fmt.Printf("stdout: %v, stderr: %v\n", stdOut, stdErr)
+	audit(ctx, bn.config, fmt.Sprintf("exec %q", cmd),
+		fmt.Sprintf("%v/%v/pods/%v/%v/exec", bn.contextName, bn.namespace, bn.pod, bn.name), err)
+	// The output read back is the command's stdout followed by its stderr.
+	output := append(append([]byte(nil), stdOut...), stdErr...)
+	if errors.Is(err, context.Canceled) {
+		// The command was interrupted, so its output is left showing as
+		// much as it had written, and that it didn't finish.
+		bn.setOutput(append(output, fmt.Sprintf("\n[kubefs: %q was interrupted, its output may be incomplete]\n", strings.Join(cmd, " "))...))
+		return 0, syscall.EINTR
+	}
+	if status, exited := kube.ExitStatus(err); exited {
+		// The command ran but failed, which is no fault of the pod or
+		// container, so its output is kept along with how it exited.
+		bn.setOutput(append(output, fmt.Sprintf("\n[kubefs: %q exited with status %v]\n", strings.Join(cmd, " "), status)...))
+		return uint32(len(buf)), 0
+	}
 	if err != nil {
-		eout := fmt.Errorf("err while executing: %w", err)
-		fmt.Print(eout, "\n")
-		bn.content = []byte(fmt.Sprint(eout))
-		//return 0, syscall.EREMOTEIO
-		return 0, syscall.ENOENT
+		err = diagnose(ctx, bn.contextName, bn.pod, bn.name, bn.namespace, err)
+		recordError(bn.stateStore, bn.dir, fmt.Errorf("failed to exec %q | %w", cmd, err))
+		return 0, errnoFor(err)
 	}
-	bn.mu.Lock()
-	defer bn.mu.Unlock()
-
-	sz := int64(len(buf))
-	// if off+sz > int64(len(bn.content)) {
-	// 	// bn.resize(uint64(off + sz))
-	// }
-	copy(bn.content[off:], buf)
-	bn.mtime = time.Now()
-
-	bn.content = stdOut
+	bn.setOutput(output)
 
 	// We report back to the filesytem that the number of bytes sent to us were
 	// written, even though we stored the response in the file.
-	return uint32(sz), 0
+	return uint32(len(buf)), 0
 }
 
 var _ = (fs.NodeReader)((*ContainerExecFile)(nil))
 
 func (bn *ContainerExecFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
-	fmt.Printf(">> execfile READ offset=%v : %v\n", off, string(bn.content))
-	bn.mu.Lock()
-	defer bn.mu.Unlock()
-
+	output := bn.output()
+	if off >= int64(len(output)) {
+		return fuse.ReadResultData(nil), 0
+	}
 	end := off + int64(len(dest))
-	if end > int64(len(bn.content)) {
-		end = int64(len(bn.content))
+	if end > int64(len(output)) {
+		end = int64(len(output))
 	}
 
-	return fuse.ReadResultData(bn.content[off:end]), 0
+	return fuse.ReadResultData(output[off:end]), 0
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
 
+	"rorycrispin.co.uk/kubefs/config"
 	kube "rorycrispin.co.uk/kubefs/kubernetes"
 )
 
@@ -18,20 +19,38 @@ type RootContextNode struct {
 	fs.Inode
 
 	stateStore *State
+	config     *config.Config
 }
 
 func (n *RootContextNode) Path() string {
 	return ""
 }
 
-func NewRootContextNode() *RootContextNode {
+func NewRootContextNode(cfg *config.Config) *RootContextNode {
 	fmt.Printf(">>> Creating new statestore\n")
-	s := NewState()
+	s := NewState(cfg.CacheMaxEntries, cfg.CacheMaxBytes)
 	return &RootContextNode{
 		stateStore: s,
+		config:     cfg,
 	}
 }
 
+// FlushCaches drops everything cached for every context, so that the next
+// access goes back to the API server.
+func (n *RootContextNode) FlushCaches() {
+	n.stateStore.Flush()
+}
+
+// CacheStats reports the size and effectiveness of the cache.
+func (n *RootContextNode) CacheStats() StateStats {
+	return n.stateStore.Stats()
+}
+
+// recordedErrors are the failures to read the kubeconfig.
+func (n *RootContextNode) recordedErrors() dirErrors {
+	return dirErrors{n.stateStore, n.Path()}
+}
+
 var _ = (fs.NodeReaddirer)((*RootContextNode)(nil))
 
 // // Readdir is part of the NodeReaddirer interface
@@ -40,12 +59,13 @@ func (n *RootContextNode) Readdir(_ context.Context) (fs.DirStream, syscall.Errn
 
 	results, err := kube.GetK8sContexts()
 	if err != nil {
-		panic(err)
+		n.recordedErrors().record(fmt.Errorf("failed to read contexts from kubeconfig | %w", err))
+		return readDirErrResponse(n.Path())
 	}
 
-	entries := make([]fuse.DirEntry, 0, len(results))
+	entries := make([]fuse.DirEntry, 0, len(results)+1)
 	for _, p := range results {
-		if p == "" {
+		if p == "" || !n.config.ContextAllowed(p) {
 			continue
 		}
 		entries = append(entries, fuse.DirEntry{
@@ -54,17 +74,24 @@ func (n *RootContextNode) Readdir(_ context.Context) (fs.DirStream, syscall.Errn
 			Mode: fuse.S_IFDIR,
 		})
 	}
-	return fs.NewListDirStream(entries), 0
+	return fs.NewListDirStream(n.recordedErrors().withEntry(entries)), 0
 }
 
 func (n *RootContextNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	fmt.Printf("LOOKUP OF RootContextNode %s' \n", name)
+	if name == "error" {
+		return n.recordedErrors().lookup(ctx, &n.Inode, n.config)
+	}
+	if !n.config.ContextAllowed(name) {
+		return nil, syscall.ENOENT
+	}
 
 	ch := n.NewInode(
 		ctx,
 		&RootContextObjectsNode{
 			name: name,
 			stateStore: n.stateStore,
+			config:     n.config,
 		},
 		fs.StableAttr{
 			Mode: syscall.S_IFDIR,
@@ -75,6 +102,15 @@ func (n *RootContextNode) Lookup(ctx context.Context, name string, out *fuse.Ent
 }
 
 
+var _ = (fs.NodeUnlinker)((*RootContextNode)(nil))
+
+// Unlink dismisses the error file.
+func (n *RootContextNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	if name != "error" {
+		return syscall.EPERM
+	}
+	return n.recordedErrors().dismiss()
+}
 
 type RootContextObjectsNode struct {
 	// Must embed an Inode for the struct to work as a node.
@@ -82,15 +118,19 @@ type RootContextObjectsNode struct {
 
 	name string
 	stateStore *State
+	config     *config.Config
 }
 
 func (n *RootContextObjectsNode) Path() string {
 	return n.name
 }
 
-func (n *RootContextObjectsNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	fmt.Printf("READDIR RootContextObjectsNode: ns: %s %#v\n", n.name, ctx)
+// recordedErrors are the refusals to read the context.
+func (n *RootContextObjectsNode) recordedErrors() dirErrors {
+	return dirErrors{n.stateStore, n.Path()}
+}
 
+func (n *RootContextObjectsNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
 	entries := []fuse.DirEntry{
 		{
 			Name: "resources",
@@ -102,17 +142,23 @@ func (n *RootContextObjectsNode) Readdir(ctx context.Context) (fs.DirStream, sys
 			Ino: hash(fmt.Sprintf("%v/config", n.Path())),
 			Mode: fuse.S_IFDIR,
 		},
+		unlockEntry(n.Path()),
 	}
-	return fs.NewListDirStream(entries), 0
+	return fs.NewListDirStream(n.recordedErrors().withEntry(entries)), 0
 }
 
 func (n *RootContextObjectsNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	if name == "resources" {
+		if errno := checkPolicy(n.stateStore, n.config, n.name, config.OpRead); errno != 0 {
+			n.recordedErrors().record(fmt.Errorf("reading context %v was refused by the access policy", n.name))
+			return nil, errno
+		}
 		ch := n.NewInode(
 			ctx,
 			&ResourceTypeNode{
 				contextName: n.name,
 				stateStore: n.stateStore,
+				config:     n.config,
 			},
 			fs.StableAttr{
 				Mode: syscall.S_IFDIR,
@@ -120,6 +166,10 @@ func (n *RootContextObjectsNode) Lookup(ctx context.Context, name string, out *f
 			},
 		)
 		return ch, 0
+	} else if name == ".unlock" {
+		return mkUnlockFile(ctx, &n.Inode, n.Path(), n.stateStore, n.config), 0
+	} else if name == "error" {
+		return n.recordedErrors().lookup(ctx, &n.Inode, n.config)
 	} else if name == "config" {
 		fmt.Printf("Looked up config on context: %v", n.name)
 	}
@@ -0,0 +1,208 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+)
+
+// manifestExtensions are the extensions of files which may be written into a
+// resource directory to create an object.
+var manifestExtensions = []string{".yaml", ".yml", ".json"}
+
+func isManifestName(name string) bool {
+	for _, ext := range manifestExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+var _ = (fs.NodeCreater)((*APIResourceNode)(nil))
+
+// Create makes a placeholder file which a manifest can be written to. Once it
+// is closed the object is created, and the placeholder disappears either way;
+// on failure the reason is left in the directory's error file.
+func (n *APIResourceNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (node *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	if n.config.ReadOnly {
+		return nil, nil, 0, syscall.EROFS
+	}
+	// Editors write swap and backup files next to the file being edited,
+	// which mustn't be mistaken for manifests.
+	if strings.HasPrefix(name, ".") || !isManifestName(name) {
+		return nil, nil, 0, syscall.EPERM
+	}
+	if errno := checkPolicy(n.stateStore, n.config, n.contextName, config.OpCreate); errno != 0 {
+		return nil, nil, 0, errno
+	}
+	if errno := checkSecrets(n.stateStore, n.config, n.contextName, n.groupVersion); errno != 0 {
+		return nil, nil, 0, errno
+	}
+
+	file := &NewObjectFile{
+		dir:      n,
+		filename: name,
+
+		stateStore: n.stateStore,
+		config:     n.config,
+	}
+	ch := n.NewInode(
+		ctx,
+		file,
+		fs.StableAttr{
+			Mode: syscall.S_IFREG,
+			Ino:  hash(fmt.Sprintf("%v/%v", n.Path(), name)),
+		},
+	)
+	return ch, &newObjectFileHandle{file: file}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+// ========== New object file ==========
+
+// NewObjectFile is a file being written into a resource directory, which is
+// turned into an object when closed.
+type NewObjectFile struct {
+	fs.Inode
+
+	dir      *APIResourceNode
+	filename string
+
+	stateStore *State
+	config     *config.Config
+}
+
+var _ = (fs.NodeSetattrer)((*NewObjectFile)(nil))
+
+func (f *NewObjectFile) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if h, ok := fh.(*newObjectFileHandle); ok {
+		return h.Setattr(ctx, in, out)
+	}
+	return 0
+}
+
+// create creates the object described by manifest. The object is named by
+// its manifest or, failing that, by the file's name.
+func (f *NewObjectFile) create(ctx context.Context, manifest []byte) (syscall.Errno, error) {
+	n := f.dir
+	objs, err := parseManifest(manifest)
+	if err != nil {
+		return syscall.EINVAL, fmt.Errorf("failed to create from %v | %w", f.filename, err)
+	}
+	if len(objs) != 1 {
+		return syscall.EINVAL, fmt.Errorf("failed to create from %v, it holds %v objects. Write one object per file, or use apply.yaml", f.filename, len(objs))
+	}
+	obj := objs[0]
+	if obj.GetName() == "" {
+		obj.SetName(strings.TrimSuffix(f.filename, filepath.Ext(f.filename)))
+	}
+	err = checkManifestObject(n.groupVersion, n.namespace, "", obj)
+	if err != nil {
+		return syscall.EINVAL, fmt.Errorf("failed to create from %v | %w", f.filename, err)
+	}
+
+	objectPath := fmt.Sprintf("%v/%v", n.Path(), obj.GetName())
+	if errno, err := checkUnlocked(ctx, f.stateStore, f.config, "create", n.contextName, objectPath); errno != 0 {
+		return errno, err
+	}
+
+	_, err = kube.CreateUnstructured(
+		ctx, n.contextName, obj.GetNamespace(),
+		n.groupVersion.GVR(), obj,
+		f.config.FieldManager,
+	)
+	audit(ctx, f.config, "create", objectPath, err)
+	if err != nil {
+		return errnoFor(err), fmt.Errorf("failed to create %v from %v | %w", obj.GetName(), f.filename, err)
+	}
+
+	// Drop any negative entry the kernel holds for the new object.
+	go n.NotifyEntry(obj.GetName())
+	return 0, nil
+}
+
+// remove takes the placeholder out of the directory.
+func (f *NewObjectFile) remove() {
+	n := f.dir
+	n.RmChild(f.filename)
+	go n.NotifyEntry(f.filename)
+}
+
+// ========== New object file handle ==========
+
+type newObjectFileHandle struct {
+	fileBuffer
+	file *NewObjectFile
+}
+
+var _ = (fs.FileReader)((*newObjectFileHandle)(nil))
+
+func (fh *newObjectFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	return fh.read(dest, off), 0
+}
+
+var _ = (fs.FileWriter)((*newObjectFileHandle)(nil))
+
+func (fh *newObjectFileHandle) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.write(data, off)
+	return uint32(len(data)), 0
+}
+
+var _ = (fs.FileSetattrer)((*newObjectFileHandle)(nil))
+
+func (fh *newObjectFileHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if sz, ok := in.GetSize(); ok {
+		fh.resize(int64(sz))
+		fh.dirty = true
+	}
+	out.Size = uint64(len(fh.buf))
+	return 0
+}
+
+var _ = (fs.FileGetattrer)((*newObjectFileHandle)(nil))
+
+func (fh *newObjectFileHandle) Getattr(ctx context.Context, out *fuse.AttrOut) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	out.Size = uint64(len(fh.buf))
+	return 0
+}
+
+var _ = (fs.FileFlusher)((*newObjectFileHandle)(nil))
+
+// Flush creates the object, so that a failure is reported by close(2).
+func (fh *newObjectFileHandle) Flush(ctx context.Context) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if !fh.dirty || len(fh.buf) == 0 {
+		return 0
+	}
+	fh.dirty = false
+
+	errno, err := fh.file.create(ctx, fh.buf)
+	fh.file.dir.recordedErrors().record(err)
+	return errno
+}
+
+var _ = (fs.FileReleaser)((*newObjectFileHandle)(nil))
+
+func (fh *newObjectFileHandle) Release(ctx context.Context) syscall.Errno {
+	fh.file.remove()
+	return 0
+}
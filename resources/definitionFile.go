@@ -0,0 +1,178 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+)
+
+// definitionFormat is the encoding an object's definition is rendered in.
+type definitionFormat int
+
+const (
+	formatJSON definitionFormat = iota
+	formatYAML
+)
+
+// definitionFiles maps the name of each definition file to its format.
+var definitionFiles = map[string]definitionFormat{
+	"def.json": formatJSON,
+	"def.yaml": formatYAML,
+}
+
+// editFiles maps the name of each editable definition file to its format.
+var editFiles = map[string]definitionFormat{
+	"edit.json": formatJSON,
+	"edit.yaml": formatYAML,
+}
+
+func (f definitionFormat) String() string {
+	if f == formatYAML {
+		return "yaml"
+	}
+	return "json"
+}
+
+func (f definitionFormat) render(obj *unstructured.Unstructured) ([]byte, error) {
+	return f.marshal(obj.Object)
+}
+
+func (f definitionFormat) marshal(v any) ([]byte, error) {
+	var rv []byte
+	var err error
+	if f == formatYAML {
+		rv, err = yaml.Marshal(v)
+	} else {
+		rv, err = json.MarshalIndent(v, "", "    ")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error encountered while marshalling resource definition to %v | %w", f, err)
+	}
+	return rv, nil
+}
+
+// parseObject parses a single object, keeping its integers as integers.
+func (f definitionFormat) parseObject(data []byte) (*unstructured.Unstructured, error) {
+	if f == formatYAML {
+		var err error
+		data, err = yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	obj := &unstructured.Unstructured{}
+	err := obj.UnmarshalJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// getDefinition fetches the named object, from the watch cache if enabled,
+// and renders it. The returned bool reports whether the watch cache served
+// it, in which case the kernel will be told when it changes.
+func getDefinition(
+	ctx context.Context, cfg *config.Config,
+	contextName, name, namespace string, res *GroupedAPIResource,
+	format definitionFormat,
+) ([]byte, bool, error) {
+	if w := watchFor(ctx, cfg, contextName, res, namespace); w != nil {
+		obj, exists := w.Get(name)
+		if !exists {
+			return nil, true, kube.ErrNotFound
+		}
+		content, err := format.render(obj)
+		return content, true, err
+	}
+
+	obj, err := kube.GetUnstructuredRaw(ctx, contextName, name, namespace, res.GVR())
+	if err != nil {
+		return nil, false, err
+	}
+	content, err := format.render(obj)
+	return content, false, err
+}
+
+// ========== Generic definition file ==========
+
+// GenericDefinitionFile is the read only def.json or def.yaml of an object.
+type GenericDefinitionFile struct {
+	fs.Inode
+
+	name         string
+	namespace    string
+	contextName  string
+	groupVersion *GroupedAPIResource
+	format       definitionFormat
+	// dir is the path of the object's directory, whose error file explains
+	// failures to read the definition.
+	dir string
+
+	stateStore *State
+	config     *config.Config
+}
+
+var _ = (fs.NodeOpener)((*GenericDefinitionFile)(nil))
+
+func (f *GenericDefinitionFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	if openFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
+		// disallow writes
+		return nil, 0, syscall.EROFS
+	}
+
+	if f.groupVersion == nil {
+		fh = &roBytesFileHandle{
+			content: []byte("error while opening definition file, groupVersion ptr was nil\n"),
+		}
+		return fh, fuse.FOPEN_DIRECT_IO, 0
+	}
+	if errno := checkSecrets(f.stateStore, f.config, f.contextName, f.groupVersion); errno != 0 {
+		return nil, 0, errno
+	}
+
+	content, watched, err := getDefinition(ctx, f.config, f.contextName, f.name, f.namespace, f.groupVersion, f.format)
+	if err != nil {
+		if !errors.Is(err, kube.ErrNotFound) {
+			recordError(f.stateStore, f.dir, fmt.Errorf("failed to read def.%v | %w", f.format, err))
+		}
+		return nil, 0, errnoFor(err)
+	}
+
+	fh = &roBytesFileHandle{
+		content: content,
+	}
+	if watched {
+		// The watch tells the kernel when the object changes, so the
+		// content may be cached.
+		return fh, fuse.FOPEN_KEEP_CACHE, 0
+	}
+	// Return FOPEN_DIRECT_IO so content is not cached.
+	return fh, fuse.FOPEN_DIRECT_IO, 0
+}
+
+var _ = (fs.NodeGetattrer)((*GenericDefinitionFile)(nil))
+
+// Getattr reports the size of definitions served from the watch cache, which
+// the kernel needs in order to cache their content. Otherwise the size is
+// left at zero, and reads go straight to Open's handle.
+func (f *GenericDefinitionFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	if f.groupVersion == nil || watchFor(ctx, f.config, f.contextName, f.groupVersion, f.namespace) == nil {
+		return 0
+	}
+	content, _, err := getDefinition(ctx, f.config, f.contextName, f.name, f.namespace, f.groupVersion, f.format)
+	if err != nil {
+		return errnoFor(err)
+	}
+	out.Size = uint64(len(content))
+	return 0
+}
@@ -0,0 +1,270 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+)
+
+// The xattrs of a resource directory which override the mount's defaults for
+// deleting its objects.
+const (
+	gracePeriodXattr = "user.kubefs.gracePeriodSeconds"
+	propagationXattr = "user.kubefs.propagationPolicy"
+)
+
+// deleteOptions are the options set through a resource directory's xattrs.
+// They are pinned in the state store, as the directory's node may be
+// forgotten by the kernel at any time, and losing them to eviction or a flush
+// would quietly turn an Orphan deletion into a cascading one.
+type deleteOptions struct {
+	gracePeriodSeconds *int64
+	propagation        string
+}
+
+func (n *APIResourceNode) deleteOptionsPath() string {
+	return fmt.Sprintf("%v#deleteOptions", n.Path())
+}
+
+func (n *APIResourceNode) deleteOptions() deleteOptions {
+	elem, exists := n.stateStore.Get(n.deleteOptionsPath())
+	if !exists {
+		return deleteOptions{}
+	}
+	opts, ok := elem.(deleteOptions)
+	if !ok {
+		panic("failed type assertion")
+	}
+	return opts
+}
+
+var _ = (fs.NodeRmdirer)((*APIResourceNode)(nil))
+
+// Rmdir deletes the named object, with the grace period and propagation
+// policy of the directory's xattrs, or else the mount's defaults.
+func (n *APIResourceNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	if n.config.ReadOnly {
+		return syscall.EROFS
+	}
+	if errno := checkPolicy(n.stateStore, n.config, n.contextName, config.OpDelete); errno != 0 {
+		return errno
+	}
+	objectPath := fmt.Sprintf("%v/%v", n.Path(), name)
+	defer n.finishRemoval(ctx, objectPath)
+	if errno, err := checkUnlocked(ctx, n.stateStore, n.config, "delete", n.contextName, objectPath); errno != 0 {
+		n.recordedErrors().record(err)
+		return errno
+	}
+
+	opts := n.deleteOptions()
+	gracePeriod := n.config.DeleteGracePeriodSeconds
+	if opts.gracePeriodSeconds != nil {
+		gracePeriod = opts.gracePeriodSeconds
+	}
+	propagation := n.config.DeletePropagation
+	if opts.propagation != "" {
+		propagation = opts.propagation
+	}
+
+	err := kube.DeleteUnstructured(
+		ctx, n.contextName, name, n.namespace,
+		n.groupVersion.GVR(),
+		gracePeriod, propagation,
+	)
+	audit(ctx, n.config, "delete", objectPath, err)
+	if err != nil {
+		n.recordedErrors().record(fmt.Errorf("failed to delete %v | %w", name, err))
+		return errnoFor(err)
+	}
+	return 0
+}
+
+var _ = (fs.NodeGetxattrer)((*APIResourceNode)(nil))
+
+func (n *APIResourceNode) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	opts := n.deleteOptions()
+	var value string
+	switch {
+	case attr == gracePeriodXattr && opts.gracePeriodSeconds != nil:
+		value = strconv.FormatInt(*opts.gracePeriodSeconds, 10)
+	case attr == propagationXattr && opts.propagation != "":
+		value = opts.propagation
+	default:
+		return 0, syscall.ENODATA
+	}
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), 0
+}
+
+var _ = (fs.NodeSetxattrer)((*APIResourceNode)(nil))
+
+func (n *APIResourceNode) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) syscall.Errno {
+	if n.config.ReadOnly {
+		return syscall.EROFS
+	}
+	opts := n.deleteOptions()
+	value := string(data)
+	switch attr {
+	case gracePeriodXattr:
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds < 0 {
+			return syscall.EINVAL
+		}
+		opts.gracePeriodSeconds = &seconds
+	case propagationXattr:
+		if value == "" || !config.ValidPropagation(value) {
+			return syscall.EINVAL
+		}
+		opts.propagation = value
+	default:
+		return syscall.ENOTSUP
+	}
+	n.stateStore.Pin(n.deleteOptionsPath(), opts)
+	return 0
+}
+
+var _ = (fs.NodeRemovexattrer)((*APIResourceNode)(nil))
+
+func (n *APIResourceNode) Removexattr(ctx context.Context, attr string) syscall.Errno {
+	if n.config.ReadOnly {
+		return syscall.EROFS
+	}
+	opts := n.deleteOptions()
+	switch {
+	case attr == gracePeriodXattr && opts.gracePeriodSeconds != nil:
+		opts.gracePeriodSeconds = nil
+	case attr == propagationXattr && opts.propagation != "":
+		opts.propagation = ""
+	default:
+		return syscall.ENODATA
+	}
+	n.stateStore.Pin(n.deleteOptionsPath(), opts)
+	return 0
+}
+
+var _ = (fs.NodeListxattrer)((*APIResourceNode)(nil))
+
+func (n *APIResourceNode) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+	opts := n.deleteOptions()
+	var names []byte
+	if opts.gracePeriodSeconds != nil {
+		names = append(names, gracePeriodXattr+"\x00"...)
+	}
+	if opts.propagation != "" {
+		names = append(names, propagationXattr+"\x00"...)
+	}
+	if len(dest) < len(names) {
+		return uint32(len(names)), syscall.ERANGE
+	}
+	return uint32(copy(dest, names)), 0
+}
+
+// removeView acknowledges the removal of a file or directory within an
+// object's directory. These are views of the object which only go away along
+// with it, but accepting their removal lets `rm -r` work its way down to the
+// object's directory, whose removal deletes the object.
+func removeView(stateStore *State, cfg *config.Config, contextName string) syscall.Errno {
+	if cfg.ReadOnly {
+		return syscall.EROFS
+	}
+	return checkPolicy(stateStore, cfg, contextName, config.OpDelete)
+}
+
+// removalWindow is how long after `rm -r` starts removing an object's views
+// its directory is expected to be removed.
+const removalWindow = time.Minute
+
+// removingPath is where it is noted in the state store that the object at
+// objectPath is having its views removed, as by `rm -r`.
+func removingPath(objectPath string) string {
+	return fmt.Sprintf("%v#removing", objectPath)
+}
+
+// removeObjectView is removeView for the object's own views, which also notes
+// that its directory is on its way out. `rm -r` unlinks everything in an
+// object's directory before removing it, .unlock included, and the object's
+// unlock must survive that until the rmdir it was meant for.
+func (n *APIResourceActions) removeObjectView() syscall.Errno {
+	errno := removeView(n.stateStore, n.config, n.contextName)
+	if errno == 0 {
+		n.stateStore.PutTTL(removingPath(n.Path()), true, removalWindow)
+	}
+	return errno
+}
+
+// removing reports whether the object's views are being removed.
+func (n *APIResourceActions) removing() bool {
+	_, removing := n.stateStore.Get(removingPath(n.Path()))
+	return removing
+}
+
+// finishRemoval ends the removal of the views of the object at objectPath,
+// once its directory has been removed, or failed to be. Removing the views
+// took its .unlock with them, so the object is locked again.
+func (n *APIResourceNode) finishRemoval(ctx context.Context, objectPath string) {
+	if _, removing := n.stateStore.Get(removingPath(objectPath)); !removing {
+		return
+	}
+	n.stateStore.Delete(removingPath(objectPath))
+	lock(ctx, n.stateStore, n.config, objectPath)
+}
+
+var _ = (fs.NodeRmdirer)((*PodObjectsNode)(nil))
+
+func (n *PodObjectsNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	return n.removeObjectView()
+}
+
+var _ = (fs.NodeUnlinker)((*RootContainerNode)(nil))
+
+func (n *RootContainerNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	if name == "error" {
+		return n.recordedErrors().dismiss()
+	}
+	return removeView(n.stateStore, n.config, n.contextName)
+}
+
+var _ = (fs.NodeRmdirer)((*RootContainerNode)(nil))
+
+func (n *RootContainerNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	return removeView(n.stateStore, n.config, n.contextName)
+}
+
+var _ = (fs.NodeUnlinker)((*RootContainerObjectsNode)(nil))
+
+func (n *RootContainerObjectsNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	if name == "error" {
+		return n.recordedErrors().dismiss()
+	}
+	return removeView(n.stateStore, n.config, n.contextName)
+}
+
+var _ = (fs.NodeRmdirer)((*RootContainerObjectsNode)(nil))
+
+func (n *RootContainerObjectsNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	return removeView(n.stateStore, n.config, n.contextName)
+}
+
+var _ = (fs.NodeUnlinker)((*ContainerLogsNode)(nil))
+
+// Unlink of the query file forgets its query, so that it shows all of the
+// logs again.
+func (n *ContainerLogsNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	switch name {
+	case "error":
+		return n.recordedErrors().dismiss()
+	case "query":
+		n.stateStore.Delete(logQueryPath(n.Path()))
+		return 0
+	}
+	return removeView(n.stateStore, n.config, n.logs.contextName)
+}
@@ -0,0 +1,148 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+)
+
+// deleteServer is an API server which accepts every deletion, and records the
+// paths deleted.
+type deleteServer struct {
+	mu      sync.Mutex
+	deleted []string
+}
+
+func (s *deleteServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	s.deleted = append(s.deleted, r.URL.Path)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+}
+
+func (s *deleteServer) deletions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deleted...)
+}
+
+// useServer points the "test" context at server for the rest of the test.
+func useServer(t *testing.T, server *httptest.Server) {
+	t.Helper()
+	kubeconfig := fmt.Sprintf(
+		"apiVersion: v1\nkind: Config\ncurrent-context: test\n"+
+			"clusters:\n- name: test\n  cluster:\n    server: %v\n"+
+			"users:\n- name: test\n  user:\n    token: token\n"+
+			"contexts:\n- name: test\n  context:\n    cluster: test\n    user: test\n",
+		server.URL,
+	)
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(kubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	kube.Configure(kube.ClientOptions{Kubeconfig: path, QPS: 100, Burst: 100})
+	t.Cleanup(func() { kube.Configure(kube.ClientOptions{}) })
+}
+
+func TestRemoveUnlockedObject(t *testing.T) {
+	configMaps := &GroupedAPIResource{
+		ResourceName: "configmaps",
+		Namespaced:   true,
+		Version:      "v1",
+		Kind:         "ConfigMap",
+	}
+
+	tests := []struct {
+		name string
+		// rm is what is unlinked from the object's directory before it is
+		// removed, or nil for everything it lists, as `rm -r` does.
+		rm        []string
+		wantErrno syscall.Errno
+	}{
+		{
+			name:      "rm -r",
+			wantErrno: 0,
+		},
+		{
+			name:      "rm .unlock then rmdir",
+			rm:        []string{".unlock"},
+			wantErrno: syscall.EROFS,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &deleteServer{}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			useServer(t, httpServer)
+
+			ctx := context.Background()
+			stateStore := NewState(0, 0)
+			cfg := &config.Config{DeleteContexts: []string{"test"}}
+			dir := &APIResourceNode{
+				contextName:  "test",
+				groupVersion: configMaps,
+				namespace:    "dev",
+
+				stateStore: stateStore,
+				config:     cfg,
+			}
+			obj := &APIResourceActions{
+				name:         "a",
+				namespace:    "dev",
+				contextName:  "test",
+				groupVersion: configMaps,
+
+				stateStore: stateStore,
+				config:     cfg,
+			}
+
+			unlock := &UnlockFile{scope: obj.Path(), stateStore: stateStore, config: cfg}
+			if _, _, errno := unlock.Open(ctx, syscall.O_WRONLY); errno != 0 {
+				t.Fatalf("touch .unlock failed with %v", errno)
+			}
+
+			rm := tt.rm
+			if rm == nil {
+				for _, entry := range obj.objectEntries() {
+					rm = append(rm, entry.Name)
+				}
+			}
+			for _, name := range rm {
+				if errno := obj.Unlink(ctx, name); errno != 0 {
+					t.Fatalf("rm %v failed with %v", name, errno)
+				}
+			}
+
+			errno := dir.Rmdir(ctx, "a")
+			if errno != tt.wantErrno {
+				t.Fatalf("rmdir returned %v, want %v", errno, tt.wantErrno)
+			}
+			deleted := server.deletions()
+			if tt.wantErrno != 0 && len(deleted) != 0 {
+				t.Errorf("refused rmdir deleted %v", deleted)
+			}
+			if want := "/api/v1/namespaces/dev/configmaps/a"; tt.wantErrno == 0 && (len(deleted) != 1 || deleted[0] != want) {
+				t.Errorf("deleted %v, want [%v]", deleted, want)
+			}
+			if _, unlocked := unlockedUntil(stateStore, obj.Path()); unlocked {
+				t.Errorf("the object is still unlocked after its .unlock was removed")
+			}
+		})
+	}
+}
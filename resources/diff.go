@@ -0,0 +1,286 @@
+package resources
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// diffOp is one line of an edit script turning a into b.
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines returns the shortest edit script from a to b, using Myers'
+// algorithm.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	maxD := n + m
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3)
+	var trace [][]int
+
+	for d := 0; d <= maxD; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, offset)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the saved frontiers of diffLines back from the end of both
+// inputs, recovering the edit script.
+func backtrack(trace [][]int, a, b []string, offset int) []diffOp {
+	var ops []diffOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', a[x]})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, diffOp{'+', b[y]})
+		} else {
+			x--
+			ops = append(ops, diffOp{'-', a[x]})
+		}
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// splitLines splits s into lines, without their trailing newlines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// unifiedDiff renders the changes from a to b as a unified diff with three
+// lines of context, or returns "" if they are the same.
+func unifiedDiff(aName, bName, a, b string) string {
+	const context = 3
+
+	ops := diffLines(splitLines(a), splitLines(b))
+	changed := false
+	for _, op := range ops {
+		if op.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %v\n+++ %v\n", aName, bName)
+
+	// aLine and bLine are the line numbers, from zero, of ops[i] in a and b.
+	aLine, bLine := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+
+		// Extend the hunk until there are more than 2*context unchanged
+		// lines in a row, or the end.
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				end += context
+				if end > run {
+					end = run
+				}
+				break
+			}
+			end = run
+		}
+
+		hunkA, hunkB := aLine-(i-start), bLine-(i-start)
+		var aCount, bCount int
+		var body strings.Builder
+		for _, op := range ops[start:end] {
+			switch op.kind {
+			case ' ':
+				aCount++
+				bCount++
+			case '-':
+				aCount++
+			case '+':
+				bCount++
+			}
+			fmt.Fprintf(&body, "%c%v\n", op.kind, op.line)
+		}
+		fmt.Fprintf(&out, "@@ -%v +%v @@\n", hunkRange(hunkA, aCount), hunkRange(hunkB, bCount))
+		out.WriteString(body.String())
+
+		for _, op := range ops[i:end] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// hunkRange formats the start and length of a hunk as unified diffs do, with
+// lines numbered from one.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%v,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%v", start+1)
+	}
+	return fmt.Sprintf("%v,%v", start+1, count)
+}
+
+// matches returns, for each line of a, the index of the line of b it is kept
+// as by the shortest edit script from a to b, or -1 if it is removed.
+func matches(a, b []string) []int {
+	rv := make([]int, len(a))
+	i, j := 0, 0
+	for _, op := range diffLines(a, b) {
+		switch op.kind {
+		case ' ':
+			rv[i] = j
+			i++
+			j++
+		case '-':
+			rv[i] = -1
+			i++
+		case '+':
+			j++
+		}
+	}
+	return rv
+}
+
+// merge3 merges the changes made to base in yours and in theirs, as diff3 -m
+// does. Where both changed the same lines differently, the merge holds both
+// versions and the base between conflict markers, and conflicts counts them.
+func merge3(base, yours, theirs []string, yoursName, theirsName string) (merged []string, conflicts int) {
+	inYours, inTheirs := matches(base, yours), matches(base, theirs)
+
+	i, j, k := 0, 0, 0
+	for i < len(base) || j < len(yours) || k < len(theirs) {
+		// Copy the lines which all three keep.
+		stable := 0
+		for i+stable < len(base) && inYours[i+stable] == j+stable && inTheirs[i+stable] == k+stable {
+			stable++
+		}
+		if stable > 0 {
+			merged = append(merged, base[i:i+stable]...)
+			i, j, k = i+stable, j+stable, k+stable
+			continue
+		}
+
+		// Otherwise the chunk runs up to the next base line which both keep.
+		nextI, nextJ, nextK := len(base), len(yours), len(theirs)
+		for n := i; n < len(base); n++ {
+			if inYours[n] >= 0 && inTheirs[n] >= 0 {
+				nextI, nextJ, nextK = n, inYours[n], inTheirs[n]
+				break
+			}
+		}
+		b, y, t := base[i:nextI], yours[j:nextJ], theirs[k:nextK]
+		switch {
+		case equalLines(y, b) || equalLines(y, t):
+			merged = append(merged, t...)
+		case equalLines(t, b):
+			merged = append(merged, y...)
+		default:
+			conflicts++
+			merged = append(merged, "<<<<<<< "+yoursName)
+			merged = append(merged, y...)
+			merged = append(merged, "||||||| base")
+			merged = append(merged, b...)
+			merged = append(merged, "=======")
+			merged = append(merged, t...)
+			merged = append(merged, ">>>>>>> "+theirsName)
+		}
+		i, j, k = nextI, nextJ, nextK
+	}
+	return merged, conflicts
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// renderForDiff renders obj for diffing, leaving out the managed fields which
+// would otherwise drown out the changes that matter.
+func renderForDiff(format definitionFormat, obj *unstructured.Unstructured) string {
+	obj = obj.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	rv, err := format.render(obj)
+	if err != nil {
+		return fmt.Sprintf("%v\n", err)
+	}
+	return string(rv)
+}
@@ -0,0 +1,75 @@
+package resources
+
+import (
+	"context"
+	"log"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+)
+
+// pagedDirStream lists the objects of an APIResourceNode a page at a time,
+// fetching the next page only once the kernel has read the previous one. If
+// a later page fails, for instance because the continue token expired, the
+// listing ends with an "error" entry and the error is kept on the node.
+type pagedDirStream struct {
+	node *APIResourceNode
+
+	// extra entries are listed before the objects.
+	extra []fuse.DirEntry
+
+	page []string
+	next string
+
+	failed      bool
+	errReported bool
+}
+
+func (s *pagedDirStream) HasNext() bool {
+	for len(s.extra) == 0 && len(s.page) == 0 && s.next != "" && !s.failed {
+		s.fetch()
+	}
+	return len(s.extra) > 0 || len(s.page) > 0 || (s.failed && !s.errReported)
+}
+
+func (s *pagedDirStream) Next() (fuse.DirEntry, syscall.Errno) {
+	if len(s.extra) > 0 {
+		e := s.extra[0]
+		s.extra = s.extra[1:]
+		return e, 0
+	}
+	if len(s.page) == 0 {
+		s.errReported = true
+		return fuse.DirEntry{
+			Name: "error",
+			Ino:  hash(recordedErrorPath(s.node.Path())),
+			Mode: fuse.S_IFREG,
+		}, 0
+	}
+
+	name := s.page[0]
+	s.page = s.page[1:]
+	return s.node.objectEntry(name), 0
+}
+
+func (s *pagedDirStream) Close() {}
+
+func (s *pagedDirStream) fetch() {
+	n := s.node
+	// DirStreams aren't given the context of the READDIR being served, so
+	// later pages are bounded by the client timeout alone.
+	names, next, err := kube.ListResourceNamesPage(
+		context.Background(), n.groupVersion.GroupVersion(), n.groupVersion.ResourceName, n.contextName, n.namespace,
+		n.config.ListPageSize, s.next,
+	)
+	if err != nil {
+		log.Printf("Listing %v failed part way through | %v", n.Path(), err)
+		n.recordedErrors().record(err)
+		s.failed = true
+		return
+	}
+	s.page = names
+	s.next = next
+}
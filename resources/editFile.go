@@ -0,0 +1,373 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+)
+
+// ========== Generic editable file ==========
+
+// GenericEditableFile is the edit.json or edit.yaml of an object. Each open
+// handle buffers its own copy of the object, which is submitted to the
+// cluster once, when the file is flushed or closed. Edits are only submitted
+// while the object is unlocked, or with the mount in read-write mode.
+type GenericEditableFile struct {
+	fs.Inode
+
+	name         string
+	namespace    string
+	contextName  string
+	groupVersion *GroupedAPIResource
+	format       definitionFormat
+	// objectPath is the path of the object's directory.
+	objectPath string
+
+	mu        sync.Mutex
+	lastError error
+
+	stateStore *State
+	config     *config.Config
+}
+
+var _ = (fs.NodeOpener)((*GenericEditableFile)(nil))
+
+func (f *GenericEditableFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	if f.config.ReadOnly && openFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+
+	if f.groupVersion == nil {
+		fh = &roBytesFileHandle{
+			content: []byte("error while opening editable file, groupVersion ptr was nil\n"),
+		}
+		return fh, fuse.FOPEN_DIRECT_IO, 0
+	}
+	if errno := checkSecrets(f.stateStore, f.config, f.contextName, f.groupVersion); errno != 0 {
+		return nil, 0, errno
+	}
+
+	obj, err := kube.GetUnstructuredRaw(
+		ctx, f.contextName, f.name, f.namespace,
+		f.groupVersion.GVR(),
+	)
+	if err != nil {
+		if !errors.Is(err, kube.ErrNotFound) {
+			recordError(f.stateStore, f.objectPath, fmt.Errorf("failed to open edit.%v | %w", f.format, err))
+		}
+		return nil, 0, errnoFor(err)
+	}
+
+	content, err := f.format.render(obj)
+	if err != nil {
+		recordError(f.stateStore, f.objectPath, fmt.Errorf("failed to render %v for editing | %w", f.name, err))
+		return nil, 0, syscall.EIO
+	}
+
+	editor := &editFileHandle{
+		fileBuffer: fileBuffer{buf: content},
+		file:       f,
+		base:       obj,
+	}
+	trackEditor(editor)
+
+	return editor, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (fdn *GenericEditableFile) Access(ctx context.Context, mask uint32) syscall.Errno {
+	// TODO: parse the mask and return a more correct value instead of always
+	// granting permission.
+	return syscall.F_OK
+}
+
+// conflictPath is where a conflicting edit is recorded in the state store,
+// for the object's edit.conflict file.
+func (f *GenericEditableFile) conflictPath() string {
+	return fmt.Sprintf("%v/edit.conflict", f.objectPath)
+}
+
+// setLastError records why the last edit of the file failed, which is also
+// shown by the object's error file.
+func (f *GenericEditableFile) setLastError(err error) {
+	recordError(f.stateStore, f.objectPath, err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastError = err
+}
+
+// ========== Edit file handle ==========
+
+// editFileHandle buffers the writes made through one open edit file. Nothing
+// is parsed until the handle is flushed.
+type editFileHandle struct {
+	fileBuffer
+	file *GenericEditableFile
+	// base is the object as it was when the file was opened.
+	base *unstructured.Unstructured
+	// complete is set when the file is fsynced or flushed, and cleared by
+	// the next write, so that it is only set while the buffer holds
+	// everything the editor meant to write.
+	complete bool
+}
+
+var _ = (fs.FileReader)((*editFileHandle)(nil))
+
+func (fh *editFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	return fh.read(dest, off), 0
+}
+
+var _ = (fs.FileWriter)((*editFileHandle)(nil))
+
+func (fh *editFileHandle) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	if fh.file.config.ReadOnly {
+		return 0, syscall.EROFS
+	}
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.write(data, off)
+	fh.complete = false
+	return uint32(len(data)), 0
+}
+
+var _ = (fs.FileSetattrer)((*editFileHandle)(nil))
+
+// Setattr handles truncation, which editors do before writing the file out.
+func (fh *editFileHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if sz, ok := in.GetSize(); ok {
+		if fh.file.config.ReadOnly {
+			return syscall.EROFS
+		}
+		fh.resize(int64(sz))
+		fh.dirty = true
+		fh.complete = false
+	}
+	out.Size = uint64(len(fh.buf))
+	return 0
+}
+
+var _ = (fs.FileGetattrer)((*editFileHandle)(nil))
+
+func (fh *editFileHandle) Getattr(ctx context.Context, out *fuse.AttrOut) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	out.Size = uint64(len(fh.buf))
+	return 0
+}
+
+var _ = (fs.FileFlusher)((*editFileHandle)(nil))
+
+// Flush is called on every close of the file, and is where the edit is
+// submitted. An error here is reported by close(2), which is how editors
+// learn that the write failed.
+func (fh *editFileHandle) Flush(ctx context.Context) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.complete = true
+	return fh.submit(ctx)
+}
+
+var _ = (fs.FileFsyncer)((*editFileHandle)(nil))
+
+// Fsync submits the edit early, so that editors which fsync before closing,
+// such as vim, report a failed edit as a failed write.
+func (fh *editFileHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.complete = true
+	return fh.submit(ctx)
+}
+
+var _ = (fs.FileReleaser)((*editFileHandle)(nil))
+
+func (fh *editFileHandle) Release(ctx context.Context) syscall.Errno {
+	untrackEditor(fh)
+	return 0
+}
+
+// submit parses the buffered edit and writes it back to the cluster, if
+// there is anything to write. Callers must hold fh.mu.
+func (fh *editFileHandle) submit(ctx context.Context) syscall.Errno {
+	if !fh.dirty {
+		return 0
+	}
+	f := fh.file
+
+	edited, err := f.format.parseObject(fh.buf)
+	if err != nil {
+		f.setLastError(fmt.Errorf("failed to parse edit of %v as %v | %w", f.name, f.format, err))
+		return syscall.EINVAL
+	}
+	// The update is sent to the object the edit names, so it mustn't have
+	// been renamed or turned into another kind of object.
+	err = checkManifestObject(f.groupVersion, f.namespace, f.name, edited)
+	if err != nil {
+		f.setLastError(fmt.Errorf("edit of %v was not submitted | %w", f.name, err))
+		return syscall.EINVAL
+	}
+	// That makes this f.objectPath, but the unlock and the audit log go by
+	// the object actually updated, so that they can never disagree with it.
+	objectPath := manifestObjectPath(f.contextName, f.groupVersion, edited)
+	if errno, err := checkUnlocked(ctx, f.stateStore, f.config, "edit", f.contextName, objectPath); errno != 0 {
+		f.setLastError(err)
+		return errno
+	}
+	if errno := checkPolicy(f.stateStore, f.config, f.contextName, config.OpEdit); errno != 0 {
+		f.setLastError(fmt.Errorf("edit of %v was refused by the policy for context %v", f.name, f.contextName))
+		return errno
+	}
+
+	// The update only succeeds if the object hasn't changed since it was
+	// opened, even if the user dropped the resourceVersion.
+	if edited.GetResourceVersion() == "" {
+		edited.SetResourceVersion(fh.base.GetResourceVersion())
+	}
+
+	_, err = kube.WriteUnstructured(
+		ctx, f.contextName, f.name, f.namespace,
+		f.groupVersion.GVR(),
+		edited,
+		false,
+	)
+	audit(ctx, f.config, "edit", objectPath, err)
+	if kube_errors.IsConflict(err) {
+		fh.recordConflict(ctx, edited)
+	}
+	if err != nil {
+		f.setLastError(err)
+		return errnoFor(err)
+	}
+	f.stateStore.Delete(f.conflictPath())
+	fh.dirty = false
+	return 0
+}
+
+// recordConflict stores the edit.conflict report of an edit which lost a race
+// with another change to the object. The report merges your changes with
+// those made in the cluster, so that once any conflicts are resolved it can be
+// copied back into the edit file and saved.
+func (fh *editFileHandle) recordConflict(ctx context.Context, yours *unstructured.Unstructured) {
+	f := fh.file
+	live, err := kube.GetUnstructuredRaw(ctx, f.contextName, f.name, f.namespace, f.groupVersion.GVR())
+
+	base := renderForDiff(f.format, fh.base)
+	mine := renderForDiff(f.format, yours)
+	var report strings.Builder
+	if err != nil {
+		// Without the live object there is nothing to merge with.
+		fmt.Fprintf(&report, "# Your edit of %v was not submitted, as the object changed in the cluster\n", f.name)
+		fmt.Fprintf(&report, "# after you opened it at resourceVersion %v. Failed to fetch the live object\n", fh.base.GetResourceVersion())
+		fmt.Fprintf(&report, "# to merge with | %v\n", err)
+		report.WriteString("#\n# Below are the changes you made.\n\n")
+		report.WriteString(unifiedDiff("base", "yours", base, mine))
+		f.stateStore.Pin(f.conflictPath(), &editConflict{content: []byte(report.String())})
+		return
+	}
+
+	liveName := fmt.Sprintf("live (resourceVersion %v)", live.GetResourceVersion())
+	merged, conflicts := merge3(
+		splitLines(base), splitLines(mine), splitLines(renderForDiff(f.format, live)),
+		"yours", liveName,
+	)
+	// JSON has no comments, so the explanation is left to the markers.
+	if f.format == formatYAML {
+		fmt.Fprintf(&report, "# Your edit of %v was not submitted, as the object changed in the cluster\n", f.name)
+		fmt.Fprintf(&report, "# after you opened it at resourceVersion %v. Below, your changes are merged\n", fh.base.GetResourceVersion())
+		fmt.Fprintf(&report, "# with those made in the cluster, with %v conflict(s) where both changed the\n", conflicts)
+		report.WriteString("# same lines. Resolve them, keeping the lines you want from between the\n")
+		fmt.Fprintf(&report, "# markers, then copy this file into edit.%v and save it. Remove this file\n", f.format)
+		report.WriteString("# once you are done with it.\n")
+	}
+	for _, line := range merged {
+		report.WriteString(line)
+		report.WriteString("\n")
+	}
+
+	f.stateStore.Pin(f.conflictPath(), &editConflict{content: []byte(report.String())})
+}
+
+// flushPending submits any writes which are still buffered. It is called when
+// the filesystem is shutting down. Writes made since the file was last
+// fsynced or flushed are not submitted, as the editor may have truncated the
+// file and only written part of it back, which could still parse.
+func (fh *editFileHandle) flushPending(ctx context.Context) error {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if !fh.dirty {
+		return nil
+	}
+	if !fh.complete {
+		return fmt.Errorf("edit of %v was not submitted, it was still being written", fh.file.objectPath)
+	}
+	errno := fh.submit(ctx)
+	if errno != 0 {
+		fh.file.mu.Lock()
+		defer fh.file.mu.Unlock()
+		return fmt.Errorf("failed to submit edit of %v | %w", fh.file.name, fh.file.lastError)
+	}
+	return nil
+}
+
+// ========== Edit conflict file ==========
+
+// editConflict is the report of a conflicting edit, held in the state store.
+type editConflict struct {
+	content []byte
+}
+
+func (c *editConflict) Size() int64 {
+	return int64(len(c.content))
+}
+
+// getEditConflict returns the conflict recorded at path, if there is one.
+func getEditConflict(stateStore *State, path string) (*editConflict, bool) {
+	elem, exists := stateStore.Get(path)
+	if !exists {
+		return nil, false
+	}
+	conflict, ok := elem.(*editConflict)
+	return conflict, ok
+}
+
+// EditConflictFile is an object's edit.conflict, which only exists after an
+// edit failed because the object changed since it was opened.
+type EditConflictFile struct {
+	fs.Inode
+
+	path string
+
+	stateStore *State
+	config     *config.Config
+}
+
+var _ = (fs.NodeOpener)((*EditConflictFile)(nil))
+
+func (f *EditConflictFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	if openFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	conflict, exists := getEditConflict(f.stateStore, f.path)
+	if !exists {
+		return nil, 0, syscall.ENOENT
+	}
+	fh = &roBytesFileHandle{
+		content: conflict.content,
+	}
+	return fh, fuse.FOPEN_DIRECT_IO, 0
+}
@@ -0,0 +1,99 @@
+package resources
+
+import (
+	"context"
+	"strings"
+	"syscall"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"rorycrispin.co.uk/kubefs/config"
+)
+
+func TestEditRejectsAnotherObject(t *testing.T) {
+	configMaps := &GroupedAPIResource{
+		ResourceName: "configmaps",
+		Namespaced:   true,
+		Version:      "v1",
+		Kind:         "ConfigMap",
+	}
+	base := &unstructured.Unstructured{}
+	base.SetAPIVersion("v1")
+	base.SetKind("ConfigMap")
+	base.SetName("a")
+	base.SetNamespace("dev")
+	base.SetResourceVersion("1")
+
+	tests := []struct {
+		name   string
+		edit   func(obj *unstructured.Unstructured)
+		reason string
+	}{
+		{
+			name:   "renamed",
+			edit:   func(obj *unstructured.Unstructured) { obj.SetName("b") },
+			reason: `name "b" doesn't match "a"`,
+		},
+		{
+			name:   "moved namespace",
+			edit:   func(obj *unstructured.Unstructured) { obj.SetNamespace("prod") },
+			reason: `namespace "prod" of a doesn't match`,
+		},
+		{
+			name:   "changed kind",
+			edit:   func(obj *unstructured.Unstructured) { obj.SetKind("Secret") },
+			reason: `kind "Secret" doesn't match`,
+		},
+		{
+			name:   "changed apiVersion",
+			edit:   func(obj *unstructured.Unstructured) { obj.SetAPIVersion("apps/v1") },
+			reason: `apiVersion "apps/v1" doesn't match`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edited := base.DeepCopy()
+			tt.edit(edited)
+			content, err := formatYAML.render(edited)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stateStore := NewState(0, 0)
+			// Unlocked, so that only the check of the object stops the edit
+			// before it reaches a cluster.
+			f := &GenericEditableFile{
+				name:         "a",
+				namespace:    "dev",
+				contextName:  "test",
+				groupVersion: configMaps,
+				format:       formatYAML,
+				objectPath:   "test/resources/v1/configmaps/namespaces/dev/a",
+
+				stateStore: stateStore,
+				config:     &config.Config{ReadWrite: true},
+			}
+			fh := &editFileHandle{
+				fileBuffer: fileBuffer{buf: content, dirty: true},
+				file:       f,
+				base:       base,
+			}
+
+			fh.mu.Lock()
+			errno := fh.submit(context.Background())
+			fh.mu.Unlock()
+			if errno != syscall.EINVAL {
+				t.Fatalf("submit returned %v, want EINVAL", errno)
+			}
+			recorded, exists := dirErrors{stateStore, f.objectPath}.last()
+			if !exists {
+				t.Fatalf("the rejected edit left no error in %v", f.objectPath)
+			}
+			if !strings.Contains(recorded.err.Error(), tt.reason) {
+				t.Errorf("recorded error is %q, want it to say %q", recorded.err, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package resources
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+)
+
+// errnoFor translates an error from talking to a cluster into the errno which
+// best describes it, so that scripts can tell failures apart. The errno can't
+// carry the message, so callers should also record the error where the
+// user can read it, such as with recordError.
+func errnoFor(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, kube.ErrNotFound), kube_errors.IsNotFound(err):
+		return syscall.ENOENT
+	case kube_errors.IsForbidden(err), kube_errors.IsUnauthorized(err):
+		return syscall.EACCES
+	case kube_errors.IsConflict(err):
+		// The object changed underneath the request, or a field it set is
+		// owned by another manager.
+		return syscall.EBUSY
+	case kube_errors.IsAlreadyExists(err):
+		return syscall.EEXIST
+	case kube_errors.IsInvalid(err), kube_errors.IsBadRequest(err):
+		return syscall.EINVAL
+	case kube_errors.IsTimeout(err), kube_errors.IsServerTimeout(err),
+		errors.Is(err, context.DeadlineExceeded):
+		return syscall.ETIMEDOUT
+	case kube_errors.IsTooManyRequests(err), kube_errors.IsServiceUnavailable(err):
+		return syscall.EAGAIN
+	case errors.Is(err, kube.ErrContinueExpired),
+		kube_errors.IsResourceExpired(err), kube_errors.IsGone(err):
+		return syscall.ESTALE
+	case kube_errors.IsMethodNotSupported(err):
+		return syscall.ENOTSUP
+	case kube_errors.IsRequestEntityTooLargeError(err):
+		return syscall.EFBIG
+	case errors.Is(err, context.Canceled):
+		return syscall.EINTR
+	case utilnet.IsConnectionRefused(err):
+		return syscall.ECONNREFUSED
+	default:
+		return syscall.EIO
+	}
+}
+
+// ========== Recorded errors ==========
+
+// recordedErrorPath is where the last error of the directory at dir is held in
+// the state store, for its error file.
+func recordedErrorPath(dir string) string {
+	return fmt.Sprintf("%v/error", dir)
+}
+
+// recordError records err as the last error of the directory at dir, or
+// clears it if err is nil.
+func recordError(stateStore *State, dir string, err error) {
+	if err == nil {
+		stateStore.Delete(recordedErrorPath(dir))
+		return
+	}
+	log.Printf("%v | %v", dir, err)
+	stateStore.Pin(recordedErrorPath(dir), recordedError{
+		err:  err,
+		time: time.Now(),
+		path: dir,
+	})
+}
+
+// recordedError is an error held in the state store, along with when and
+// where it happened.
+type recordedError struct {
+	err  error
+	time time.Time
+	path string
+}
+
+// render describes the error for its error file, along with the API request
+// it was returned for and the status the API server gave, when known.
+func (r recordedError) render() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "time: %v\n", r.time.UTC().Format(time.RFC3339))
+	if r.path != "" {
+		fmt.Fprintf(&b, "path: %v\n", r.path)
+	}
+	var reqErr *kube.RequestError
+	if errors.As(r.err, &reqErr) {
+		fmt.Fprintf(&b, "request: %v %v\n", reqErr.Method, reqErr.Path)
+	}
+	var status kube_errors.APIStatus
+	if errors.As(r.err, &status) {
+		if code := status.Status().Code; code != 0 {
+			fmt.Fprintf(&b, "status: %v %v\n", code, http.StatusText(int(code)))
+		}
+		if reason := status.Status().Reason; reason != "" {
+			fmt.Fprintf(&b, "reason: %v\n", reason)
+		}
+	}
+	fmt.Fprintf(&b, "error: %v\n", r.err)
+	return b.Bytes()
+}
+
+// dirErrors are the errors recorded against the directory at dir. Every
+// directory lists an error file while it has one, and removing the file
+// dismisses it.
+type dirErrors struct {
+	stateStore *State
+	dir        string
+}
+
+func (d dirErrors) last() (recordedError, bool) {
+	elem, exists := d.stateStore.Get(recordedErrorPath(d.dir))
+	if !exists {
+		return recordedError{}, false
+	}
+	rec, ok := elem.(recordedError)
+	if !ok {
+		panic("failed type assertion")
+	}
+	return rec, true
+}
+
+// LastError returns the directory's last error, or nil if it has none.
+func (d dirErrors) LastError() error {
+	rec, exists := d.last()
+	if !exists {
+		return nil
+	}
+	return rec.err
+}
+
+func (d dirErrors) record(err error) {
+	recordError(d.stateStore, d.dir, err)
+}
+
+// withEntry appends the error file's entry to entries if there is an error.
+func (d dirErrors) withEntry(entries []fuse.DirEntry) []fuse.DirEntry {
+	if _, exists := d.last(); !exists {
+		return entries
+	}
+	return append(entries, fuse.DirEntry{
+		Name: "error",
+		Ino:  hash(recordedErrorPath(d.dir)),
+		Mode: fuse.S_IFREG,
+	})
+}
+
+// lookup returns the error file, or ENOENT if there is no error.
+func (d dirErrors) lookup(ctx context.Context, parent *fs.Inode, cfg *config.Config) (*fs.Inode, syscall.Errno) {
+	if _, exists := d.last(); !exists {
+		return nil, syscall.ENOENT
+	}
+	ch := parent.NewInode(
+		ctx,
+		&ErrorFile{
+			recorded: d,
+
+			stateStore: d.stateStore,
+			config:     cfg,
+		},
+		fs.StableAttr{
+			Mode: syscall.S_IFREG,
+			Ino:  hash(recordedErrorPath(d.dir)),
+		},
+	)
+	return ch, 0
+}
+
+// dismiss clears the error, as `rm error` does.
+func (d dirErrors) dismiss() syscall.Errno {
+	if _, exists := d.last(); !exists {
+		return syscall.ENOENT
+	}
+	d.record(nil)
+	return 0
+}
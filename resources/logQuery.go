@@ -0,0 +1,440 @@
+package resources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"rorycrispin.co.uk/kubefs/config"
+)
+
+// logQuery selects part of a container's logs. Queries are written as terms
+// separated by commas or spaces, such as "since-15m,tail-100":
+//
+//	all                    the whole log
+//	tail-N                 only the last N lines
+//	since-15m              lines logged in the last 15 minutes
+//	since-<RFC3339 time>   lines logged from then on
+//	until-15m              lines logged up to 15 minutes ago
+//	until-<RFC3339 time>   lines logged up to then
+//	timestamps             prefix each line with when it was logged
+//	previous               the logs of the container's previous run
+//	follow                 carry on with lines as they are logged
+//
+// Relative times are resolved when the logs are opened.
+type logQuery struct {
+	tailLines  *int64
+	since      time.Duration
+	sinceTime  time.Time
+	until      time.Duration
+	untilTime  time.Time
+	timestamps bool
+	previous   bool
+	follow     bool
+}
+
+func parseLogQuery(query string) (logQuery, error) {
+	var q logQuery
+	terms := strings.FieldsFunc(query, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	for _, term := range terms {
+		name, arg, _ := strings.Cut(term, "-")
+		switch name {
+		case "all":
+		case "timestamps":
+			q.timestamps = true
+		case "previous":
+			q.previous = true
+		case "follow":
+			q.follow = true
+		case "tail":
+			lines, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || lines < 0 {
+				return logQuery{}, fmt.Errorf("%q should be tail-N, the number of lines to show", term)
+			}
+			q.tailLines = &lines
+		case "since":
+			var err error
+			q.since, q.sinceTime, err = parseLogTime(arg)
+			if err != nil {
+				return logQuery{}, fmt.Errorf("%q | %w", term, err)
+			}
+		case "until":
+			var err error
+			q.until, q.untilTime, err = parseLogTime(arg)
+			if err != nil {
+				return logQuery{}, fmt.Errorf("%q | %w", term, err)
+			}
+		default:
+			return logQuery{}, fmt.Errorf("%q is not a log query, try tail-N, since-15m, since-<RFC3339 time>, until-..., timestamps, previous or follow", term)
+		}
+	}
+	return q, nil
+}
+
+// parseLogTime parses either a positive duration, meaning that long ago, or
+// an RFC3339 time.
+func parseLogTime(s string) (time.Duration, time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return 0, time.Time{}, fmt.Errorf("the duration must be positive")
+		}
+		return d, time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("expected a duration such as 15m or an RFC3339 time such as 2026-10-17T10:00:00Z")
+	}
+	return 0, t, nil
+}
+
+// options returns the PodLogOptions fetching the container's logs for the
+// query. Logs are timestamped if the query has an end, so that it can be
+// found; open strips the timestamps again if they weren't asked for.
+func (q logQuery) options(container string) corev1.PodLogOptions {
+	opts := corev1.PodLogOptions{
+		Container:  container,
+		Previous:   q.previous,
+		Follow:     q.follow,
+		TailLines:  q.tailLines,
+		Timestamps: q.timestamps || q.hasEnd(),
+	}
+	if q.since != 0 {
+		seconds := int64(math.Ceil(q.since.Seconds()))
+		opts.SinceSeconds = &seconds
+	} else if !q.sinceTime.IsZero() {
+		opts.SinceTime = &metav1.Time{Time: q.sinceTime}
+	}
+	return opts
+}
+
+func (q logQuery) hasEnd() bool {
+	return q.until != 0 || !q.untilTime.IsZero()
+}
+
+// end returns when the query's logs end, resolved against now.
+func (q logQuery) end(now time.Time) time.Time {
+	if q.until != 0 {
+		return now.Add(-q.until)
+	}
+	return q.untilTime
+}
+
+// untilReader ends a stream of timestamped log lines at the first line
+// logged after until, which ends the query's time window. Since logs are in
+// order, nothing after it is fetched.
+type untilReader struct {
+	stream     io.ReadCloser
+	lines      *bufio.Reader
+	until      time.Time
+	timestamps bool
+
+	pending []byte
+	done    bool
+}
+
+func newUntilReader(stream io.ReadCloser, until time.Time, timestamps bool) *untilReader {
+	return &untilReader{
+		stream:     stream,
+		lines:      bufio.NewReaderSize(stream, logsChunk),
+		until:      until,
+		timestamps: timestamps,
+	}
+}
+
+func (r *untilReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		line, err := r.lines.ReadBytes('\n')
+		if err == io.EOF {
+			r.done = true
+		} else if err != nil {
+			return 0, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		stamp, rest, found := bytes.Cut(line, []byte(" "))
+		logged, parseErr := time.Parse(time.RFC3339Nano, string(stamp))
+		if !found || parseErr != nil {
+			// Not a timestamped line, so pass it on as it is.
+			r.pending = line
+			continue
+		}
+		if logged.After(r.until) {
+			r.done = true
+			continue
+		}
+		if r.timestamps {
+			r.pending = line
+		} else {
+			r.pending = rest
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *untilReader) Close() error {
+	return r.stream.Close()
+}
+
+// ========== Container logs.d directory ==========
+
+// logQueryExamples are the queries listed in a logs.d directory. Any other
+// query may be looked up by name.
+var logQueryExamples = []string{
+	"all",
+	"previous",
+	"timestamps",
+	"tail-100",
+	"since-15m",
+	"since-1h",
+}
+
+// ContainerLogsNode is a container's logs.d directory, which sits alongside
+// its logs file. Each file in it is the part of the logs selected by the
+// query it is named after, so that logs.d/since-15m is the last 15 minutes
+// of logs, and the query file shows whichever query was last written to it.
+type ContainerLogsNode struct {
+	fs.Inode
+
+	// logs are the whole of the container's logs, which each file narrows
+	// with its query.
+	logs containerLogs
+
+	stateStore *State
+	config     *config.Config
+}
+
+func (n *ContainerLogsNode) Path() string {
+	return n.logs.dir
+}
+
+// recordedErrors are the failures to fetch logs, and the invalid queries
+// written to the query file.
+func (n *ContainerLogsNode) recordedErrors() dirErrors {
+	return dirErrors{n.stateStore, n.Path()}
+}
+
+// withQuery returns the logs selected by q.
+func (n *ContainerLogsNode) withQuery(q logQuery) containerLogs {
+	logs := n.logs
+	logs.query = q
+	return logs
+}
+
+var _ = (fs.NodeReaddirer)((*ContainerLogsNode)(nil))
+
+func (n *ContainerLogsNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	entries := make([]fuse.DirEntry, 0, len(logQueryExamples)+2)
+	for _, name := range logQueryExamples {
+		entries = append(entries, fuse.DirEntry{
+			Name: name,
+			Ino:  hash(fmt.Sprintf("%v/%v", n.Path(), name)),
+			Mode: fuse.S_IFREG,
+		})
+	}
+	entries = append(entries, fuse.DirEntry{
+		Name: "query",
+		Ino:  hash(logQueryPath(n.Path())),
+		Mode: fuse.S_IFREG,
+	})
+	return fs.NewListDirStream(n.recordedErrors().withEntry(entries)), 0
+}
+
+var _ = (fs.NodeLookuper)((*ContainerLogsNode)(nil))
+
+func (n *ContainerLogsNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if name == "error" {
+		return n.recordedErrors().lookup(ctx, &n.Inode, n.config)
+	}
+
+	var node fs.InodeEmbedder
+	if name == "query" {
+		node = &ContainerLogQueryFile{
+			logs: n.logs,
+
+			stateStore: n.stateStore,
+			config:     n.config,
+		}
+	} else {
+		q, err := parseLogQuery(name)
+		if err != nil {
+			return nil, syscall.ENOENT
+		}
+		if q.follow {
+			node = &ContainerFollowFile{
+				logs: n.withQuery(q),
+
+				stateStore: n.stateStore,
+				config:     n.config,
+			}
+		} else {
+			node = &ContainerLogsFile{
+				logs: n.withQuery(q),
+
+				stateStore: n.stateStore,
+				config:     n.config,
+			}
+		}
+	}
+	ch := n.NewInode(
+		ctx, node,
+		fs.StableAttr{
+			Mode: syscall.S_IFREG,
+			Ino:  hash(fmt.Sprintf("%v/%v", n.Path(), name)),
+		},
+	)
+	return ch, 0
+}
+
+// ========== Container log query file ==========
+
+// ContainerLogQueryFile is the query file of a logs.d directory. A query written
+// to it is kept, and reading it shows the logs the query selects, or all of
+// them if no query has been written.
+type ContainerLogQueryFile struct {
+	fs.Inode
+
+	logs containerLogs
+
+	stateStore *State
+	config     *config.Config
+}
+
+// logQueryPath is where the query of the logs.d directory at dir is held in the
+// state store.
+func logQueryPath(dir string) string {
+	return fmt.Sprintf("%v/query", dir)
+}
+
+// query returns the text of the last query written to the file.
+func (f *ContainerLogQueryFile) query() string {
+	elem, exists := f.stateStore.Get(logQueryPath(f.logs.dir))
+	if !exists {
+		return ""
+	}
+	query, ok := elem.(string)
+	if !ok {
+		panic("failed type assertion")
+	}
+	return query
+}
+
+var _ = (fs.NodeOpener)((*ContainerLogQueryFile)(nil))
+
+func (f *ContainerLogQueryFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	if openFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
+		// Writing holds the query to read the logs with, which starts out as
+		// the current one so that it can be edited.
+		fh = &logQueryFileHandle{
+			fileBuffer: fileBuffer{buf: []byte(f.query())},
+			file:       f,
+		}
+		return fh, fuse.FOPEN_DIRECT_IO, 0
+	}
+
+	// The stored query was checked when it was written.
+	q, _ := parseLogQuery(f.query())
+	logs := f.logs
+	logs.query = q
+	stream, errno := logs.open(ctx, f.stateStore)
+	if errno != 0 {
+		return nil, 0, errno
+	}
+	fh = &logsFileHandle{
+		logs:       logs,
+		stream:     stream,
+		stateStore: f.stateStore,
+	}
+	// Return FOPEN_DIRECT_IO so content is not cached.
+	return fh, fuse.FOPEN_DIRECT_IO, 0
+}
+
+var _ = (fs.NodeSetattrer)((*ContainerLogQueryFile)(nil))
+
+func (f *ContainerLogQueryFile) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if h, ok := fh.(*logQueryFileHandle); ok {
+		return h.Setattr(ctx, in, out)
+	}
+	return 0
+}
+
+// ========== Log query file handle ==========
+
+type logQueryFileHandle struct {
+	fileBuffer
+	file *ContainerLogQueryFile
+}
+
+var _ = (fs.FileReader)((*logQueryFileHandle)(nil))
+
+func (fh *logQueryFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	return fh.read(dest, off), 0
+}
+
+var _ = (fs.FileWriter)((*logQueryFileHandle)(nil))
+
+func (fh *logQueryFileHandle) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.write(data, off)
+	return uint32(len(data)), 0
+}
+
+var _ = (fs.FileSetattrer)((*logQueryFileHandle)(nil))
+
+func (fh *logQueryFileHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if sz, ok := in.GetSize(); ok {
+		fh.resize(int64(sz))
+		fh.dirty = true
+	}
+	out.Size = uint64(len(fh.buf))
+	return 0
+}
+
+var _ = (fs.FileFlusher)((*logQueryFileHandle)(nil))
+
+// Flush keeps the query if it is valid, so that an invalid one is reported by
+// close(2) and explained in the directory's error file.
+func (fh *logQueryFileHandle) Flush(ctx context.Context) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if !fh.dirty {
+		return 0
+	}
+	fh.dirty = false
+
+	f := fh.file
+	query := strings.TrimSpace(string(fh.buf))
+	if _, err := parseLogQuery(query); err != nil {
+		recordError(f.stateStore, f.logs.dir, fmt.Errorf("failed to set the log query | %w", err))
+		return syscall.EINVAL
+	}
+	f.stateStore.Pin(logQueryPath(f.logs.dir), query)
+	return 0
+}
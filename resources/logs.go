@@ -0,0 +1,421 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+)
+
+const (
+	// logsChunk is how much of a log stream is fetched at a time.
+	logsChunk = 64 << 10
+	// logsWindow is how much of a log a reader may re-read without the log
+	// being fetched again from the start.
+	logsWindow = 1 << 20
+	// followRetain is how much of a followed log is kept for readers which
+	// have fallen behind, or which seek back from its end as tail does.
+	followRetain = 8 << 20
+	// followTailLines is how many of the lines already logged logs-follow
+	// starts with.
+	followTailLines int64 = 100
+)
+
+// containerLogs identifies the logs of a container selected by a query, and
+// the directory whose error file explains failures to fetch them.
+type containerLogs struct {
+	pod         string
+	namespace   string
+	container   string
+	contextName string
+	query       logQuery
+	dir         string
+}
+
+// open starts streaming the logs. Failures are recorded, along with the state
+// of the pod and container, which usually explains them.
+func (l containerLogs) open(ctx context.Context, stateStore *State) (io.ReadCloser, syscall.Errno) {
+	var rc io.ReadCloser
+	cli, err := kube.ClientsFor(l.contextName).Typed()
+	if err == nil {
+		opts := l.query.options(l.container)
+		rc, err = kube.StreamLogs(ctx, cli, l.pod, l.namespace, &opts)
+	}
+	if err != nil {
+		if !errors.Is(err, kube.ErrNotFound) && !errors.Is(err, context.Canceled) {
+			err = diagnose(ctx, l.contextName, l.pod, l.container, l.namespace, err)
+			recordError(stateStore, l.dir, fmt.Errorf("failed to fetch logs | %w", err))
+		}
+		return nil, errnoFor(err)
+	}
+	if l.query.hasEnd() {
+		rc = newUntilReader(rc, l.query.end(time.Now()), l.query.timestamps)
+	}
+	return rc, 0
+}
+
+// ========== Container Logs file ==========
+
+// ContainerLogsFile is a container's logs or logs-previous, or one of the
+// queries in its logs.d directory. Logs are streamed from the API server as they are read
+// rather than fetched whole, so that reading a huge log doesn't hold all of
+// it in memory.
+type ContainerLogsFile struct {
+	fs.Inode
+
+	logs containerLogs
+
+	stateStore *State
+	config     *config.Config
+}
+
+var _ = (fs.NodeOpener)((*ContainerLogsFile)(nil))
+
+func (f *ContainerLogsFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	// disallow writes
+	if openFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+
+	// The stream is opened now so that failures are reported by open(2).
+	stream, errno := f.logs.open(ctx, f.stateStore)
+	if errno != 0 {
+		return nil, 0, errno
+	}
+	fh = &logsFileHandle{
+		logs:       f.logs,
+		stream:     stream,
+		stateStore: f.stateStore,
+	}
+	// Return FOPEN_DIRECT_IO so content is not cached.
+	return fh, fuse.FOPEN_DIRECT_IO, 0
+}
+
+// logsFileHandle reads a log stream lazily, fetching it only as far as it
+// has been read. Only the last logsWindow of what was fetched is kept, and
+// reading from before that starts the stream over.
+type logsFileHandle struct {
+	mu sync.Mutex
+
+	logs       containerLogs
+	stream     io.ReadCloser
+	stateStore *State
+
+	// window holds the most recently fetched part of the log, starting at
+	// offset base.
+	window []byte
+	base   int64
+	eof    bool
+}
+
+var _ = (fs.FileReader)((*logsFileHandle)(nil))
+
+func (fh *logsFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if off < fh.base {
+		if errno := fh.restart(ctx); errno != 0 {
+			return nil, errno
+		}
+	}
+	end := off + int64(len(dest))
+	for fh.base+int64(len(fh.window)) < end && !fh.eof {
+		if errno := fh.fetch(ctx); errno != 0 {
+			return nil, errno
+		}
+		fh.trim(off)
+	}
+
+	fetched := fh.base + int64(len(fh.window))
+	if off >= fetched {
+		return fuse.ReadResultData(nil), 0
+	}
+	if end > fetched {
+		end = fetched
+	}
+	rv := make([]byte, end-off)
+	copy(rv, fh.window[off-fh.base:end-fh.base])
+	return fuse.ReadResultData(rv), 0
+}
+
+// trim drops what was fetched before keep, apart from the last logsWindow of
+// it. The window is only copied once it has grown to twice that.
+func (fh *logsFileHandle) trim(keep int64) {
+	if len(fh.window) <= 2*logsWindow {
+		return
+	}
+	drop := int64(len(fh.window) - logsWindow)
+	if keep-fh.base < drop {
+		drop = keep - fh.base
+	}
+	if drop <= 0 {
+		return
+	}
+	fh.window = append([]byte(nil), fh.window[drop:]...)
+	fh.base += drop
+}
+
+// fetch appends the next chunk of the stream to the window. A followed stream
+// may wait for lines to be logged, so if ctx is cancelled while it waits, the
+// stream is ended and the read interrupted.
+func (fh *logsFileHandle) fetch(ctx context.Context) syscall.Errno {
+	stream := fh.stream
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Close()
+		case <-stop:
+		}
+	}()
+
+	buf := make([]byte, logsChunk)
+	n, err := stream.Read(buf)
+	fh.window = append(fh.window, buf[:n]...)
+	if ctx.Err() != nil {
+		fh.eof = true
+		return syscall.EINTR
+	}
+	if err == io.EOF {
+		fh.eof = true
+		return 0
+	}
+	if err != nil {
+		recordError(fh.stateStore, fh.logs.dir, fmt.Errorf("failed to read logs | %w", err))
+		return errnoFor(err)
+	}
+	return 0
+}
+
+// restart reopens the stream, for a reader which went back past the window.
+func (fh *logsFileHandle) restart(ctx context.Context) syscall.Errno {
+	fh.stream.Close()
+	stream, errno := fh.logs.open(ctx, fh.stateStore)
+	if errno != 0 {
+		// Leave a stream which fails every read, rather than none.
+		fh.stream = io.NopCloser(errReader{syscall.EIO})
+		return errno
+	}
+	fh.stream = stream
+	fh.window = nil
+	fh.base = 0
+	fh.eof = false
+	return 0
+}
+
+var _ = (fs.FileReleaser)((*logsFileHandle)(nil))
+
+func (fh *logsFileHandle) Release(ctx context.Context) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.stream.Close()
+	return 0
+}
+
+// errReader fails every read with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// ========== Container follow file ==========
+
+// ContainerFollowFile is the logs-follow of a container: its last
+// followTailLines lines, followed by every line logged while the file is
+// open. Reads at the end of the file wait for more to be logged, so cat,
+// grep --line-buffered and less +F keep printing, and the file's size grows
+// as lines arrive so that tail -f works too. Everyone with the file open
+// shares one stream, which ends once the last of them closes it.
+type ContainerFollowFile struct {
+	fs.Inode
+
+	logs containerLogs
+
+	mu       sync.Mutex
+	follower *logFollower
+	handles  int
+
+	stateStore *State
+	config     *config.Config
+}
+
+var _ = (fs.NodeOpener)((*ContainerFollowFile)(nil))
+
+func (f *ContainerFollowFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	// disallow writes
+	if openFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.follower == nil {
+		stream, errno := f.logs.open(ctx, f.stateStore)
+		if errno != 0 {
+			return nil, 0, errno
+		}
+		f.follower = newLogFollower(stream, func() {
+			// Let stat see the new size.
+			f.NotifyContent(0, 0)
+		})
+	}
+	f.handles++
+	fh = &followFileHandle{
+		file:     f,
+		follower: f.follower,
+	}
+	// Return FOPEN_DIRECT_IO so content is not cached.
+	return fh, fuse.FOPEN_DIRECT_IO, 0
+}
+
+var _ = (fs.NodeGetattrer)((*ContainerFollowFile)(nil))
+
+// Getattr reports how much of the log has arrived so far.
+func (f *ContainerFollowFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.follower != nil {
+		out.Size = uint64(f.follower.size())
+	}
+	return 0
+}
+
+// release ends the stream once nobody has the file open.
+func (f *ContainerFollowFile) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handles--
+	if f.handles == 0 && f.follower != nil {
+		f.follower.close()
+		f.follower = nil
+	}
+}
+
+type followFileHandle struct {
+	file     *ContainerFollowFile
+	follower *logFollower
+}
+
+var _ = (fs.FileReader)((*followFileHandle)(nil))
+
+func (fh *followFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	return fh.follower.read(ctx, dest, off)
+}
+
+var _ = (fs.FileReleaser)((*followFileHandle)(nil))
+
+func (fh *followFileHandle) Release(ctx context.Context) syscall.Errno {
+	fh.file.release()
+	return 0
+}
+
+// logFollower reads a followed log stream as it arrives, keeping the last
+// followRetain of it.
+type logFollower struct {
+	stream io.ReadCloser
+	notify func()
+
+	mu sync.Mutex
+	// data holds the log from offset base.
+	data []byte
+	base int64
+	// changed is closed, and replaced, whenever data arrives or the stream
+	// ends.
+	changed chan struct{}
+	done    bool
+}
+
+func newLogFollower(stream io.ReadCloser, notify func()) *logFollower {
+	l := &logFollower{
+		stream:  stream,
+		notify:  notify,
+		changed: make(chan struct{}),
+	}
+	go l.pump()
+	return l
+}
+
+func (l *logFollower) pump() {
+	buf := make([]byte, logsChunk)
+	for {
+		n, err := l.stream.Read(buf)
+
+		l.mu.Lock()
+		l.data = append(l.data, buf[:n]...)
+		if excess := len(l.data) - followRetain; excess > followRetain {
+			l.data = append([]byte(nil), l.data[excess:]...)
+			l.base += int64(excess)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Following logs ended | %v", err)
+			}
+			l.done = true
+		}
+		close(l.changed)
+		l.changed = make(chan struct{})
+		done := l.done
+		l.mu.Unlock()
+
+		if n > 0 {
+			l.notify()
+		}
+		if done {
+			return
+		}
+	}
+}
+
+func (l *logFollower) size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.base + int64(len(l.data))
+}
+
+// read waits until there is data at off, or the stream has ended.
+func (l *logFollower) read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	for {
+		l.mu.Lock()
+		if off < l.base {
+			l.mu.Unlock()
+			log.Printf("Read of followed logs at %v, which have been dropped up to %v", off, l.base)
+			return nil, syscall.EIO
+		}
+		if end := l.base + int64(len(l.data)); off < end {
+			n := copy(dest, l.data[off-l.base:])
+			l.mu.Unlock()
+			return fuse.ReadResultData(dest[:n]), 0
+		}
+		if l.done {
+			l.mu.Unlock()
+			return fuse.ReadResultData(nil), 0
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, syscall.EINTR
+		}
+	}
+}
+
+func (l *logFollower) close() {
+	l.stream.Close()
+}
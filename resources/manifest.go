@@ -0,0 +1,94 @@
+package resources
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// parseManifest splits a YAML or JSON manifest into its objects.
+func parseManifest(manifest []byte) ([]*unstructured.Unstructured, error) {
+	var rv []*unstructured.Unstructured
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(manifest)))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest | %w", err)
+		}
+		if len(strings.TrimSpace(string(doc))) == 0 {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{}
+		err = yaml.Unmarshal(doc, &obj.Object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse manifest | %w", err)
+		}
+		if obj.Object == nil {
+			continue
+		}
+		rv = append(rv, obj)
+	}
+	if len(rv) == 0 {
+		return nil, fmt.Errorf("manifest contains no objects")
+	}
+	return rv, nil
+}
+
+// manifestObjectPath is the path of the directory of obj, an object of
+// resource res, which is what its changes are unlocked by and audited as.
+func manifestObjectPath(contextName string, res *GroupedAPIResource, obj *unstructured.Unstructured) string {
+	if res.Namespaced {
+		return fmt.Sprintf("%v/resources/%v/%v/namespaces/%v/%v",
+			contextName, res.GroupVersion(), res.ResourceName, obj.GetNamespace(), obj.GetName(),
+		)
+	}
+	return fmt.Sprintf("%v/resources/%v/%v/%v",
+		contextName, res.GroupVersion(), res.ResourceName, obj.GetName(),
+	)
+}
+
+// checkManifestObject checks obj is of resource res and belongs in namespace,
+// filling in the namespace if it was left out. If name is set, obj must be
+// that object, and its name is filled in if left out.
+func checkManifestObject(res *GroupedAPIResource, namespace, name string, obj *unstructured.Unstructured) error {
+	if obj.GetAPIVersion() != res.GroupVersion() {
+		return fmt.Errorf("apiVersion %q doesn't match this directory's %q", obj.GetAPIVersion(), res.GroupVersion())
+	}
+	if res.Kind != "" && obj.GetKind() != res.Kind {
+		return fmt.Errorf("kind %q doesn't match this directory's %q", obj.GetKind(), res.Kind)
+	}
+
+	if name != "" {
+		if obj.GetName() == "" {
+			obj.SetName(name)
+		} else if obj.GetName() != name {
+			return fmt.Errorf("name %q doesn't match %q, apply it from its own directory", obj.GetName(), name)
+		}
+	} else if obj.GetName() == "" {
+		return fmt.Errorf("object of kind %q has no name", obj.GetKind())
+	}
+
+	if !res.Namespaced {
+		if obj.GetNamespace() != "" {
+			return fmt.Errorf("%v are not namespaced, but %v has namespace %q", res.ResourceName, obj.GetName(), obj.GetNamespace())
+		}
+		return nil
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(namespace)
+	} else if obj.GetNamespace() != namespace {
+		return fmt.Errorf("namespace %q of %v doesn't match this directory's %q", obj.GetNamespace(), obj.GetName(), namespace)
+	}
+	return nil
+}
@@ -7,8 +7,8 @@ import (
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
-	k8s "k8s.io/client-go/kubernetes"
 
+	"rorycrispin.co.uk/kubefs/config"
 	kube "rorycrispin.co.uk/kubefs/kubernetes"
 )
 
@@ -23,46 +23,38 @@ type ListGenericNamespaceNode struct {
 	contextName  string
 	groupVersion *GroupedAPIResource
 
-	lastError error
-
-	cli        *k8s.Clientset
 	stateStore *State
+	config     *config.Config
 }
 
 func (n *ListGenericNamespaceNode) Path() string {
 	return fmt.Sprintf("%v/resources/%v/%v/namespaces",
-		n.contextName, n.groupVersion.GroupVersion, n.groupVersion.ResourceName,
+		n.contextName, n.groupVersion.GroupVersion(), n.groupVersion.ResourceName,
 	)
 }
 
-var _ = (fs.NodeReaddirer)((*ListGenericNamespaceNode)(nil))
-
-func (n *ListGenericNamespaceNode) ensureClientSet() error {
-	if n.cli != nil {
-		return nil
-	}
-	cli, err := kube.GetK8sClient(n.contextName)
-	if err != nil {
-		return err
-	}
-	n.cli = cli
-	return nil
+// recordedErrors are the failures to list the namespaces.
+func (n *ListGenericNamespaceNode) recordedErrors() dirErrors {
+	return dirErrors{n.stateStore, n.Path()}
 }
 
+var _ = (fs.NodeReaddirer)((*ListGenericNamespaceNode)(nil))
+
 // // Readdir is part of the NodeReaddirer interface
 func (n *ListGenericNamespaceNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	err := n.ensureClientSet()
+	cli, err := kube.ClientsFor(n.contextName).Typed()
 	if err != nil {
-		panic(err)
+		n.recordedErrors().record(fmt.Errorf("failed to get client while listing namespaces | %w", err))
+		return readDirErrResponse(n.Path())
 	}
 
-	results, err := kube.GetNamespaces(ctx, n.cli)
+	results, err := kube.GetNamespaces(ctx, cli)
 	if err != nil {
-		n.lastError = err
+		n.recordedErrors().record(fmt.Errorf("failed to list namespaces | %w", err))
 		return readDirErrResponse(n.Path())
 	}
 
-	entries := make([]fuse.DirEntry, 0, len(results))
+	entries := make([]fuse.DirEntry, 0, len(results)+1)
 	for _, p := range results {
 		if p == "" {
 			continue
@@ -73,15 +65,13 @@ func (n *ListGenericNamespaceNode) Readdir(ctx context.Context) (fs.DirStream, s
 			Mode: fuse.S_IFDIR,
 		})
 	}
-	return fs.NewListDirStream(entries), 0
+	return fs.NewListDirStream(n.recordedErrors().withEntry(entries)), 0
 
 }
 
 func (n *ListGenericNamespaceNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	if name == "error" {
-		// TODO rc return a file whose string contents is the error
-		fmt.Printf("Error is %v", n.lastError)
-		return nil, syscall.ENOENT
+		return n.recordedErrors().lookup(ctx, &n.Inode, n.config)
 	}
 
 	ch := n.NewInode(
@@ -92,6 +82,7 @@ func (n *ListGenericNamespaceNode) Lookup(ctx context.Context, name string, out
 			groupVersion: n.groupVersion,
 
 			stateStore: n.stateStore,
+			config:     n.config,
 		},
 		fs.StableAttr{
 			Mode: syscall.S_IFDIR,
@@ -100,3 +91,13 @@ func (n *ListGenericNamespaceNode) Lookup(ctx context.Context, name string, out
 	)
 	return ch, 0
 }
+
+var _ = (fs.NodeUnlinker)((*ListGenericNamespaceNode)(nil))
+
+// Unlink dismisses the error file.
+func (n *ListGenericNamespaceNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	if name != "error" {
+		return syscall.EPERM
+	}
+	return n.recordedErrors().dismiss()
+}
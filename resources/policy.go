@@ -0,0 +1,194 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"rorycrispin.co.uk/kubefs/config"
+)
+
+// checkPolicy returns EACCES unless the policy allows op in the context. An
+// operation needing confirmation is allowed while the context is unlocked.
+func checkPolicy(stateStore *State, cfg *config.Config, contextName string, op config.Operation) syscall.Errno {
+	switch cfg.Check(contextName, op) {
+	case config.Allowed:
+		return 0
+	case config.NeedsConfirmation:
+		if _, unlocked := unlockedUntil(stateStore, contextName); unlocked {
+			return 0
+		}
+		log.Printf("Refused %v in context %v, write to %v/.unlock to confirm it", op, contextName, contextName)
+		return syscall.EACCES
+	default:
+		log.Printf("Refused %v in context %v, the policy doesn't allow it", op, contextName)
+		return syscall.EACCES
+	}
+}
+
+// checkSecrets additionally checks the secrets operation for access to the
+// contents of Secrets.
+func checkSecrets(stateStore *State, cfg *config.Config, contextName string, res *GroupedAPIResource) syscall.Errno {
+	if res == nil || res.Group != "" || res.ResourceName != "secrets" {
+		return 0
+	}
+	return checkPolicy(stateStore, cfg, contextName, config.OpSecrets)
+}
+
+// An unlock's scope is the path of the directory holding the .unlock file: a
+// context, a resource's namespace directory or an object.
+func unlockPath(scope string) string {
+	return fmt.Sprintf("%v#unlocked", scope)
+}
+
+// unlockedUntil returns when the scope's unlock runs out, if it is unlocked.
+func unlockedUntil(stateStore *State, scope string) (time.Time, bool) {
+	elem, exists := stateStore.Get(unlockPath(scope))
+	if !exists {
+		return time.Time{}, false
+	}
+	until, ok := elem.(time.Time)
+	if !ok {
+		panic("failed type assertion")
+	}
+	return until, true
+}
+
+// unlockedAny reports whether any of scopes is unlocked.
+func unlockedAny(stateStore *State, scopes []string) bool {
+	for _, scope := range scopes {
+		if _, unlocked := unlockedUntil(stateStore, scope); unlocked {
+			return true
+		}
+	}
+	return false
+}
+
+// unlocked reports whether changes may be made to the object at objectPath,
+// as the mount is read-write or the object, its resource's directory or its
+// context has been unlocked.
+func unlocked(stateStore *State, cfg *config.Config, contextName, objectPath string) bool {
+	if cfg.ReadWrite {
+		return true
+	}
+	return unlockedAny(stateStore, []string{objectPath, path.Dir(objectPath), contextName})
+}
+
+// checkUnlocked returns EROFS, and the reason to show the user, unless the
+// object at objectPath may be changed. Every edit, apply, creation and
+// deletion checks it before touching the cluster, and a refusal is audited
+// as action just as the change itself would have been.
+func checkUnlocked(ctx context.Context, stateStore *State, cfg *config.Config, action, contextName, objectPath string) (syscall.Errno, error) {
+	if unlocked(stateStore, cfg, contextName, objectPath) {
+		return 0, nil
+	}
+	err := fmt.Errorf(
+		"%v of %v was refused as it is locked, touch .unlock in its directory, in %v or in %v first",
+		action, path.Base(objectPath), path.Dir(objectPath), contextName,
+	)
+	audit(ctx, cfg, action, objectPath, err)
+	return syscall.EROFS, err
+}
+
+// lock removes the unlock of scope, as `rm .unlock` does.
+func lock(ctx context.Context, stateStore *State, cfg *config.Config, scope string) syscall.Errno {
+	if cfg.ReadOnly {
+		return syscall.EROFS
+	}
+	if _, unlocked := unlockedUntil(stateStore, scope); unlocked {
+		stateStore.Delete(unlockPath(scope))
+		audit(ctx, cfg, "lock", scope, nil)
+	}
+	return 0
+}
+
+// ========== Unlock file ==========
+
+// UnlockFile is the .unlock of a context, a resource's namespace directory or
+// an object. Opening it for writing, as `touch` does, unlocks its directory
+// for the policy's unlockFor. An unlocked context allows the operations the
+// policy has marked for confirmation, and unlocked objects and anything below
+// an unlocked directory may be changed; see checkUnlocked. Removing the file locks
+// its directory again. Reading it shows whether the directory is unlocked.
+type UnlockFile struct {
+	fs.Inode
+
+	scope string
+
+	stateStore *State
+	config     *config.Config
+}
+
+// mkUnlockFile returns the .unlock file of the directory at scope.
+func mkUnlockFile(ctx context.Context, parent *fs.Inode, scope string, stateStore *State, cfg *config.Config) *fs.Inode {
+	return parent.NewInode(
+		ctx,
+		&UnlockFile{
+			scope:      scope,
+			stateStore: stateStore,
+			config:     cfg,
+		},
+		fs.StableAttr{
+			Mode: syscall.S_IFREG,
+			Ino:  hash(fmt.Sprintf("%v/.unlock", scope)),
+		},
+	)
+}
+
+// unlockEntry is the directory entry of the .unlock file of scope.
+func unlockEntry(scope string) fuse.DirEntry {
+	return fuse.DirEntry{
+		Name: ".unlock",
+		Ino:  hash(fmt.Sprintf("%v/.unlock", scope)),
+		Mode: fuse.S_IFREG,
+	}
+}
+
+var _ = (fs.NodeOpener)((*UnlockFile)(nil))
+
+func (f *UnlockFile) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	if openFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
+		if f.config.ReadOnly {
+			return nil, 0, syscall.EROFS
+		}
+		ttl := f.config.UnlockFor()
+		f.stateStore.PinTTL(unlockPath(f.scope), time.Now().Add(ttl), ttl)
+		audit(ctx, f.config, fmt.Sprintf("unlock for %v", ttl), f.scope, nil)
+		return &rwBytesFileHandle{}, fuse.FOPEN_DIRECT_IO, 0
+	}
+
+	status := "locked\n"
+	if until, unlocked := unlockedUntil(f.stateStore, f.scope); unlocked {
+		status = fmt.Sprintf("unlocked until %v\n", until.Format(time.RFC3339))
+	}
+	fh = &roBytesFileHandle{
+		content: []byte(status),
+	}
+	return fh, fuse.FOPEN_DIRECT_IO, 0
+}
+
+var _ = (fs.NodeSetattrer)((*UnlockFile)(nil))
+
+// Setattr accepts the truncation and timestamps set by `>` and `touch`.
+func (f *UnlockFile) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	return 0
+}
+
+var _ = (fs.NodeUnlinker)((*RootContextObjectsNode)(nil))
+
+// Unlink of .unlock locks the context again, and of error dismisses it.
+func (n *RootContextObjectsNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	if name == "error" {
+		return n.recordedErrors().dismiss()
+	}
+	if name != ".unlock" {
+		return syscall.EPERM
+	}
+	return lock(ctx, n.stateStore, n.config, n.Path())
+}
@@ -0,0 +1,61 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// openEditors holds every edit handle which is currently open, so that
+// buffered writes can be submitted before the filesystem is unmounted.
+var openEditors = struct {
+	sync.Mutex
+	handles map[*editFileHandle]struct{}
+}{
+	handles: make(map[*editFileHandle]struct{}),
+}
+
+func trackEditor(fh *editFileHandle) {
+	openEditors.Lock()
+	defer openEditors.Unlock()
+	openEditors.handles[fh] = struct{}{}
+}
+
+func untrackEditor(fh *editFileHandle) {
+	openEditors.Lock()
+	defer openEditors.Unlock()
+	delete(openEditors.handles, fh)
+}
+
+// FlushPendingEdits submits the buffered writes of every open edit file which
+// were complete when it was last fsynced or flushed. It returns the combined
+// errors of any edits which could not be submitted, including those left
+// unsubmitted as they were still being written.
+func FlushPendingEdits(ctx context.Context) error {
+	openEditors.Lock()
+	handles := make([]*editFileHandle, 0, len(openEditors.handles))
+	for fh := range openEditors.handles {
+		handles = append(handles, fh)
+	}
+	openEditors.Unlock()
+
+	var errs []error
+	for _, fh := range handles {
+		if err := fh.flushPending(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors(errs)
+}
+
+// joinErrors stands in for errors.Join, which isn't available in go 1.18.
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	msg := errs[0].Error()
+	for _, err := range errs[1:] {
+		msg += "\n" + err.Error()
+	}
+	return errors.New(msg)
+}
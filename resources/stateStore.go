@@ -1,20 +1,81 @@
 package resources
 
-import "time"
+import (
+	"container/list"
+	"sync"
+	"time"
+)
 
+// defaultEntrySize is the size assumed for values which don't implement sizer.
+const defaultEntrySize = 256
+
+// sizer may be implemented by values put in the State, so that the memory cap
+// can account for them more accurately.
+type sizer interface {
+	Size() int64
+}
 
 type stateEntry struct {
-	value any
+	key    string
+	value  any
 	expiry *time.Time
+	size   int64
+	// elem is the entry's place in the LRU list, or nil if it is pinned.
+	elem *list.Element
 }
 
-type State struct  {
-	store map[string]stateEntry
+// stateCall is a load in progress, which concurrent callers of GetOrLoad for
+// the same key wait on instead of starting their own.
+type stateCall struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+// StateStats are counters describing how effective the State has been.
+// Entries and Bytes count cached entries, and Pinned the pinned ones.
+type StateStats struct {
+	Entries   int
+	Bytes     int64
+	Pinned    int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// State is the cache shared by every node of a mount. It is safe for
+// concurrent use. Entries may carry a TTL, and once the entry or memory cap is
+// reached the least recently used entries are evicted.
+//
+// The State also holds what can't be fetched again if lost, such as unlocks,
+// log queries and the errors waiting in error files. These entries are
+// pinned: they don't count towards the caps, and are neither evicted nor
+// dropped by Flush.
+type State struct {
+	mu sync.Mutex
+
+	store    map[string]*stateEntry
+	lru      *list.List
+	inflight map[string]*stateCall
+
+	// maxEntries and maxBytes cap the store. Zero means no limit.
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
 }
 
-func NewState() *State {
-	rv := State{}
-	rv.store = make(map[string]stateEntry)
+func NewState(maxEntries int, maxBytes int64) *State {
+	rv := State{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+	rv.store = make(map[string]*stateEntry)
+	rv.lru = list.New()
+	rv.inflight = make(map[string]*stateCall)
 	return &rv
 }
 
@@ -22,28 +83,213 @@ func (s *State) PutTTL(key string, value any, ttl time.Duration) {
 	e := time.Now().Add(ttl)
 	expiry := &e
 
-	s.store[key] = stateEntry{
-		value: value,
-		expiry: expiry,
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, value, expiry, false)
 }
 
 func (s *State) Put(key string, value any) {
-	s.store[key] = stateEntry{
-		value: value,
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, value, nil, false)
+}
+
+// PinTTL is PutTTL for a pinned entry, which still expires after ttl.
+func (s *State) PinTTL(key string, value any, ttl time.Duration) {
+	e := time.Now().Add(ttl)
+	expiry := &e
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, value, expiry, true)
+}
+
+// Pin stores value at key as a pinned entry, which is kept until it is
+// deleted.
+func (s *State) Pin(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, value, nil, true)
 }
 
 func (s *State) Get(key string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, exists := s.get(key)
+	if exists {
+		s.hits++
+	} else {
+		s.misses++
+	}
+	return v, exists
+}
+
+// GetOrLoad returns the value stored at key. If there is none, load is called
+// to produce it, and the result is stored with the given TTL. A ttl of zero
+// means the value doesn't expire. Concurrent callers for the same key share a
+// single call to load.
+func (s *State) GetOrLoad(key string, ttl time.Duration, load func() (any, error)) (any, error) {
+	return s.getOrLoad(key, load, func(value any) {
+		if ttl > 0 {
+			s.PutTTL(key, value, ttl)
+		} else {
+			s.Put(key, value)
+		}
+	})
+}
+
+// getOrLoad returns the value at key, or calls load and passes what it
+// returns to store.
+func (s *State) getOrLoad(key string, load func() (any, error), store func(value any)) (any, error) {
+	s.mu.Lock()
+	v, exists := s.get(key)
+	if exists {
+		s.hits++
+		s.mu.Unlock()
+		return v, nil
+	}
+	s.misses++
+
+	if c, loading := s.inflight[key]; loading {
+		s.mu.Unlock()
+		<-c.done
+		return c.value, c.err
+	}
+	c := &stateCall{done: make(chan struct{})}
+	s.inflight[key] = c
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.inflight, key)
+		s.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.value, c.err = load()
+	if c.err != nil {
+		return nil, c.err
+	}
+	store(c.value)
+	return c.value, nil
+}
+
+// Delete removes the entry at key, if any.
+func (s *State) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, exists := s.store[key]; exists {
+		s.remove(v)
+	}
+}
+
+// Flush drops every cached entry from the store, keeping those pinned.
+func (s *State) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, e := range s.store {
+		if e.elem != nil {
+			delete(s.store, key)
+		}
+	}
+	s.lru.Init()
+	s.bytes = 0
+}
+
+// Len returns the number of entries held, including expired entries which
+// have not yet been evicted.
+func (s *State) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.store)
+}
+
+func (s *State) Stats() StateStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return StateStats{
+		Entries:   s.lru.Len(),
+		Bytes:     s.bytes,
+		Pinned:    len(s.store) - s.lru.Len(),
+		Hits:      s.hits,
+		Misses:    s.misses,
+		Evictions: s.evictions,
+	}
+}
+
+// get returns the live value at key. Callers must hold s.mu.
+func (s *State) get(key string) (any, bool) {
 	v, exists := s.store[key]
 	if !exists {
 		return nil, false
 	}
-	if v.expiry != nil{
+	if v.expiry != nil {
 		if v.expiry.Before(time.Now()) {
-			delete(s.store, key)
+			s.remove(v)
 			return nil, false
 		}
 	}
+	if v.elem != nil {
+		s.lru.MoveToFront(v.elem)
+	}
 	return v.value, true
 }
+
+// put stores value at key and evicts entries until the store is within its
+// limits. Pinned entries are kept out of the LRU list, so are never evicted.
+// Callers must hold s.mu.
+func (s *State) put(key string, value any, expiry *time.Time, pinned bool) {
+	if old, exists := s.store[key]; exists {
+		s.remove(old)
+	}
+	if pinned {
+		s.store[key] = &stateEntry{
+			key:    key,
+			value:  value,
+			expiry: expiry,
+		}
+		return
+	}
+
+	var size int64 = defaultEntrySize
+	if sz, ok := value.(sizer); ok {
+		size = sz.Size()
+	}
+	e := &stateEntry{
+		key:    key,
+		value:  value,
+		expiry: expiry,
+		size:   size,
+	}
+	e.elem = s.lru.PushFront(e)
+	s.store[key] = e
+	s.bytes += size
+
+	for s.overLimit() && s.lru.Len() > 1 {
+		oldest := s.lru.Back().Value.(*stateEntry)
+		s.remove(oldest)
+		s.evictions++
+	}
+}
+
+func (s *State) overLimit() bool {
+	if s.maxEntries > 0 && s.lru.Len() > s.maxEntries {
+		return true
+	}
+	if s.maxBytes > 0 && s.bytes > s.maxBytes {
+		return true
+	}
+	return false
+}
+
+// remove drops e from the store. Callers must hold s.mu.
+func (s *State) remove(e *stateEntry) {
+	delete(s.store, e.key)
+	if e.elem != nil {
+		s.lru.Remove(e.elem)
+		s.bytes -= e.size
+	}
+}
@@ -3,7 +3,6 @@ package resources
 import (
 	"hash/fnv"
 	"syscall"
-	"fmt"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
@@ -18,14 +17,14 @@ func hash(s string) uint64 {
 }
 
 // readDirErrResponse is a helper for Readdir funcs. It returns a single
-// entry which is a regular file named Error.
-// Calling functions should store the error and present it as plaintext when
-// the user looks up this Error file.
+// entry which is a regular file named error.
+// Calling functions should record the error against the directory at path,
+// so that the error file explains it.
 func readDirErrResponse(path string) (fs.DirStream, syscall.Errno) {
 		entries := []fuse.DirEntry{
 			{
 				Name: "error",
-				Ino:  hash(fmt.Sprintf("%v/%v", path, "error")),
+				Ino:  hash(recordedErrorPath(path)),
 				Mode: fuse.S_IFREG,
 			},
 		}
@@ -0,0 +1,66 @@
+package resources
+
+import (
+	"context"
+	"log"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+)
+
+// watchFor returns the watch of res in namespace when the watch cache is
+// enabled. It returns nil if the cache is disabled, the resource can't be
+// watched, or the watch failed to start, in which case callers should ask the
+// API server directly.
+func watchFor(ctx context.Context, cfg *config.Config, contextName string, res *GroupedAPIResource, namespace string) *kube.Watch {
+	if cfg == nil || !cfg.WatchCache {
+		return nil
+	}
+	return startWatch(ctx, contextName, res, namespace)
+}
+
+// notifyWatchFor returns the watch of res in namespace when notifications are
+// enabled, or nil as watchFor does.
+func notifyWatchFor(ctx context.Context, cfg *config.Config, contextName string, res *GroupedAPIResource, namespace string) *kube.Watch {
+	if cfg == nil || !cfg.Notifications() {
+		return nil
+	}
+	return startWatch(ctx, contextName, res, namespace)
+}
+
+func startWatch(ctx context.Context, contextName string, res *GroupedAPIResource, namespace string) *kube.Watch {
+	if res == nil || !res.Watchable() {
+		return nil
+	}
+	w, err := kube.ClientsFor(contextName).Watch(ctx, *res.GVR(), namespace)
+	if err != nil {
+		log.Printf("Watch cache unavailable, falling back to list | %v", err)
+		return nil
+	}
+	return w
+}
+
+// notifyContentChanged invalidates the kernel's cached content and attributes
+// of every file directly below an object's directory.
+func notifyContentChanged(object *fs.Inode) {
+	for _, child := range object.Children() {
+		if child.IsDir() {
+			continue
+		}
+		child.NotifyContent(0, 0)
+	}
+}
+
+// heldDirStream holds a watch until the directory handle listing it is
+// released.
+type heldDirStream struct {
+	fs.DirStream
+	release func()
+}
+
+func (s *heldDirStream) Close() {
+	s.DirStream.Close()
+	s.release()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"rorycrispin.co.uk/kubefs/config"
+	kube "rorycrispin.co.uk/kubefs/kubernetes"
+	"rorycrispin.co.uk/kubefs/resources"
+)
+
+// Exit statuses, so that scripts and service managers can tell a clean
+// shutdown from one which lost data or left the mount behind.
+const (
+	exitOK              = 0
+	exitFlushFailed     = 1
+	exitUnmountFailed   = 2
+	exitPidFileFailed   = 3
+	unmountRetries      = 5
+	unmountRetryBackoff = 200 * time.Millisecond
+)
+
+// serve waits until the filesystem is unmounted, either externally or because
+// we received SIGINT/SIGTERM or an unmount control command, and returns the
+// status to exit with.
+func serve(server *fuse.Server, cfg *config.Config, unmountRequests <-chan struct{}) int {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	unmounted := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(unmounted)
+	}()
+
+	select {
+	case <-unmounted:
+		log.Printf("Filesystem was unmounted, exiting")
+		kube.Shutdown()
+		return exitOK
+	case sig := <-signals:
+		log.Printf("Received %v, shutting down. Send it again to exit immediately", sig)
+		go func() {
+			<-signals
+			log.Printf("Exiting without a clean unmount")
+			os.Exit(exitUnmountFailed)
+		}()
+	case <-unmountRequests:
+		log.Printf("Unmount requested over the control socket, shutting down")
+	}
+
+	return shutdown(server, cfg)
+}
+
+// shutdown flushes pending edits, cancels everything still in flight and
+// unmounts the filesystem.
+func shutdown(server *fuse.Server, cfg *config.Config) int {
+	status := exitOK
+
+	// Edits are submitted before requests are cancelled, otherwise the
+	// submission itself would be cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	err := resources.FlushPendingEdits(ctx)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to flush pending edits | %v", err)
+		status = exitFlushFailed
+	}
+
+	// Exec and log streams would otherwise keep the mount busy.
+	kube.Shutdown()
+
+	for i := 0; i < unmountRetries; i++ {
+		err = server.Unmount()
+		if err == nil {
+			log.Printf("Unmounted %s", cfg.MountPoint)
+			return status
+		}
+		time.Sleep(unmountRetryBackoff)
+	}
+	log.Printf("Failed to unmount %s, lazily detaching it | %v", cfg.MountPoint, err)
+	err = lazyUnmount(cfg.MountPoint)
+	if err != nil {
+		log.Printf("Failed to detach %s, run 'fusermount -u %s' | %v", cfg.MountPoint, cfg.MountPoint, err)
+		return exitUnmountFailed
+	}
+	return status
+}
+
+// recoverStaleMount detaches a mount left behind at mountPoint by a kubefs
+// process which died without unmounting. Such a mount fails every operation
+// with ENOTCONN.
+func recoverStaleMount(mountPoint string) error {
+	_, err := os.Stat(mountPoint)
+	if !errors.Is(err, syscall.ENOTCONN) {
+		return nil
+	}
+	log.Printf("Found a stale mount at %s, detaching it", mountPoint)
+	return lazyUnmount(mountPoint)
+}
+
+// lazyUnmount detaches mountPoint even if it is still in use, falling back
+// from fusermount to umount(2), which only works as root.
+func lazyUnmount(mountPoint string) error {
+	var err error
+	for _, bin := range []string{"fusermount", "fusermount3"} {
+		err = exec.Command(bin, "-u", "-z", mountPoint).Run()
+		if err == nil {
+			return nil
+		}
+	}
+	if uerr := syscall.Unmount(mountPoint, syscall.MNT_DETACH); uerr != nil {
+		return err
+	}
+	return nil
+}